@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// apiMergeRequest represents the relevant fields of a GitLab merge request.
+type apiMergeRequest struct {
+	IID          int       `json:"iid"`
+	Title        string    `json:"title"`
+	WebURL       string    `json:"web_url"`
+	TargetBranch string    `json:"target_branch"`
+	Reviewers    []apiUser `json:"reviewers"`
+}
+
+func newAssignCommand() *command {
+	fs := newFlagSet("assign")
+	var reviewers stringsFlag
+	fs.Var(&reviewers, "reviewer", "Reviewer `username` to assign (repeatable)")
+	appendReviewers := fs.Bool("append", false, "Keep the reviewers already on the merge request")
+	fields := fs.String("fields", strings.Join(conf.fields(), ","), "Comma-separated TSV `columns` of the lines on stdin, one of them username")
+
+	return &command{
+		name:    "assign",
+		args:    "[mr-iid]",
+		summary: "Set reviewers on a merge request",
+		help: `Set the reviewers of a merge request. Without an IID, the open merge
+request for the current branch is used.
+
+Reviewers are given with -reviewer, or read from stdin one per line when it
+is not a terminal. The TSV lines printed by "members" and "suggest" are
+accepted, so suggestions can be piped in:
+
+  gitlab-reviewer suggest -n 2 | gitlab-reviewer assign
+
+The username is taken from their columns as given by -fields, which
+defaults to the output.fields setting like theirs. Piping in output with
+other -fields needs the same -fields here.`,
+		flags:      fs,
+		flagValues: map[string]func() []string{"reviewer": completeUsernames, "fields": fieldNames},
+		run: func(args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+			}
+
+			usernames := []string(reviewers)
+			if len(usernames) == 0 && !isTerminal(os.Stdin) {
+				columns, err := parseFields(*fields)
+				if err != nil {
+					return err
+				}
+				if usernames, err = readUsernames(os.Stdin, columns); err != nil {
+					return err
+				}
+			}
+			if len(usernames) == 0 {
+				return fmt.Errorf("no reviewers given, use -reviewer or pipe usernames on stdin")
+			}
+
+			if options.group != "" {
+				return fmt.Errorf("merge requests belong to a project, not a group; drop --group")
+			}
+			client, err := newGitLabClient()
+			if err != nil {
+				return err
+			}
+
+			var mr *apiMergeRequest
+			if len(args) == 1 {
+				iid, err := strconv.Atoi(strings.TrimPrefix(args[0], "!"))
+				if err != nil {
+					return fmt.Errorf("invalid merge request IID %q", args[0])
+				}
+				mr, err = getMergeRequest(client, iid)
+				if err != nil {
+					return err
+				}
+			} else {
+				mr, err = currentMergeRequest(client)
+				if err != nil {
+					return err
+				}
+			}
+
+			mr, err = assignReviewers(client, mr, usernames, *appendReviewers)
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, len(mr.Reviewers))
+			for i, r := range mr.Reviewers {
+				names[i] = "@" + r.Username
+			}
+			fmt.Printf("Reviewers of !%d: %s\n%s\n", mr.IID, strings.Join(names, ", "), mr.WebURL)
+			return nil
+		},
+	}
+}
+
+// readUsernames reads one username per line from r. Tab-separated lines
+// are members/suggest output with the given fields, and the username is
+// taken from its column.
+func readUsernames(r io.Reader, fields []string) ([]string, error) {
+	column := slices.Index(fields, "username")
+	var usernames []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if values := strings.Split(line, "\t"); len(values) > 1 {
+			if column < 0 || column >= len(values) {
+				return nil, fmt.Errorf("no username column in %q; pass the -fields it was printed with", line)
+			}
+			line = values[column]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			usernames = append(usernames, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading stdin: %w", err)
+	}
+	return usernames, nil
+}
+
+func getMergeRequest(client *gitlabClient, iid int) (*apiMergeRequest, error) {
+	var mr apiMergeRequest
+	if err := client.get(client.projectPath(fmt.Sprintf("/merge_requests/%d", iid)), nil, &mr); err != nil {
+		return nil, fmt.Errorf("fetching merge request !%d: %w", iid, err)
+	}
+	return &mr, nil
+}
+
+// currentMergeRequest finds the open merge request whose source branch is
+// the currently checked out branch.
+func currentMergeRequest(client *gitlabClient) (*apiMergeRequest, error) {
+	branch, err := gitOutput("symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("not on a branch, pass a merge request IID")
+	}
+
+	var mrs []apiMergeRequest
+	params := url.Values{"source_branch": {branch}, "state": {"opened"}}
+	if err := client.get(client.projectPath("/merge_requests"), params, &mrs); err != nil {
+		return nil, fmt.Errorf("looking up merge request for %s: %w", branch, err)
+	}
+
+	switch len(mrs) {
+	case 0:
+		return nil, fmt.Errorf("no open merge request for branch %s", branch)
+	case 1:
+		return &mrs[0], nil
+	default:
+		return nil, fmt.Errorf("multiple open merge requests for branch %s, pass an IID", branch)
+	}
+}
+
+// assignReviewers sets the reviewers of mr to the given usernames, or adds
+// them to the existing reviewers when keepExisting is set.
+func assignReviewers(client *gitlabClient, mr *apiMergeRequest, usernames []string, keepExisting bool) (*apiMergeRequest, error) {
+	if err := client.require(featureReviewers); err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	seen := make(map[int]bool)
+	add := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if keepExisting {
+		for _, r := range mr.Reviewers {
+			add(r.ID)
+		}
+	}
+
+	users := make([]*apiUser, len(usernames))
+	err := batch(len(usernames), func(i int) error {
+		user, err := lookupUser(client, usernames[i])
+		users[i] = user
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		add(user.ID)
+	}
+
+	var updated apiMergeRequest
+	path := client.projectPath(fmt.Sprintf("/merge_requests/%d", mr.IID))
+	if err := client.put(path, map[string]any{"reviewer_ids": ids}, &updated); err != nil {
+		// Explain a 403 caused by a read-only token instead of passing it on
+		if isStatus(err, http.StatusForbidden) {
+			if token, infoErr := tokenInfo(client); infoErr == nil && len(token.missingScopes(true)) > 0 {
+				return nil, fmt.Errorf("updating merge request !%d: token lacks the api scope needed to assign reviewers (has: %s)", mr.IID, strings.Join(token.Scopes, ", "))
+			}
+		}
+		return nil, fmt.Errorf("updating merge request !%d: %w", mr.IID, err)
+	}
+	return &updated, nil
+}
+
+// lookupUser resolves a username (with or without a leading "@").
+func lookupUser(client *gitlabClient, username string) (*apiUser, error) {
+	username = strings.TrimPrefix(username, "@")
+
+	var users []apiUser
+	if err := client.get("/users", url.Values{"username": {username}}, &users); err != nil {
+		return nil, fmt.Errorf("looking up user %s: %w", username, err)
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("unknown user %s", username)
+	}
+	return &users[0], nil
+}
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func newAuthCommand() *command {
+	return &command{
+		name:    "auth",
+		summary: "Manage the GitLab access token",
+		help: `Manage GitLab personal access tokens. Tokens are stored per host in the
+credentials file or in the system keyring. The GITLAB_TOKEN and
+GITLAB_PRIVATE_TOKEN environment variables take precedence over both,
+followed by CI_JOB_TOKEN when running in a GitLab CI job on the same host
+and by the output of the configured token_command. Without a stored token,
+the password for the host in ~/.netrc or the token the glab CLI stored for
+the host is used, and failing those a token in ~/.gitlab_pat, which serves
+any host. With remote_credentials set, a token embedded in the HTTPS remote
+URL is the last resort.`,
+		commands: []*command{
+			newAuthLoginCommand(),
+			newAuthStatusCommand(),
+			newAuthRotateCommand(),
+			newAuthLogoutCommand(),
+		},
+	}
+}
+
+func newAuthLoginCommand() *command {
+	fs := newFlagSet("login")
+	host := &options.host // the global --host flag
+	store := fs.String("store", conf.tokenStore(), "Where to store the token: file or keyring")
+	oauth := fs.Bool("oauth", false, "Log in through the browser with OAuth instead of pasting a token")
+	clientID := fs.String("client-id", "", "`ID` of the OAuth application to use with -oauth (default: hosts.<host>.oauth_client_id)")
+
+	return &command{
+		name:    "login",
+		summary: "Store and verify a personal access token",
+		help: `Prompt for a personal access token, verify it against the GitLab API and
+store it for the host. With -store file (the default) it is written to
+the credentials file (credentials.json in the config directory, unless
+-token-file says otherwise) with mode 0600; with -store keyring
+it goes into the system keyring (Secret Service, macOS Keychain or Windows
+Credential Manager) instead. The token can also be piped in on stdin.
+
+With -oauth, log in with GitLab's device authorization flow instead: confirm
+the displayed code in the browser and a short-lived token is stored in the
+credentials file and refreshed automatically. This needs an OAuth
+application on the GitLab instance (non-confidential, with the api scope and
+the device authorization grant), whose ID is passed with -client-id or set in
+hosts.<host>.oauth_client_id. Requires GitLab 17.2 or newer.`,
+		flags: fs,
+		flagValues: map[string]func() []string{
+			"store": func() []string { return []string{storeFile, storeKeyring} },
+		},
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if *store != storeFile && *store != storeKeyring {
+				return fmt.Errorf("unknown token store %q (want file or keyring)", *store)
+			}
+			if *host == "" {
+				*host = remoteHost()
+			}
+
+			if *oauth {
+				if *clientID == "" {
+					*clientID = conf.oauthClientID(*host)
+				}
+				if *clientID == "" {
+					return fmt.Errorf("-oauth needs the ID of an OAuth application on %s: pass -client-id or set hosts.%s.oauth_client_id", *host, *host)
+				}
+
+				user, err := oauthLoginAndStore(*host, *clientID)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(stderr, "Logged in to %s as @%s\n", *host, user.Username)
+				return nil
+			}
+
+			if isTerminal(os.Stdin) {
+				fmt.Fprintf(stderr, "Create a token with the api scope at:\n  %s\n\n", tokenCreationURL(*host))
+			}
+
+			token, err := promptSecret("Paste your token: ")
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				return fmt.Errorf("no token given")
+			}
+
+			user, err := storeToken(*host, token, *store)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(stderr, "Logged in to %s as @%s\n", *host, user.Username)
+			return nil
+		},
+	}
+}
+
+func newAuthStatusCommand() *command {
+	fs := newFlagSet("status")
+	assign := fs.Bool("assign", false, "Also require the api scope that assign needs")
+
+	return &command{
+		name:    "status",
+		summary: "Show the stored token and whether it works",
+		help: `Show which token is used, the user it belongs to and its scopes. Fails if
+the token lacks the read_api scope needed to list members or, with -assign,
+the api scope needed to assign reviewers.`,
+		flags: fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			client, err := newGitLabClient()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("host:    %s\n", client.host)
+			fmt.Printf("token:   %s (%s)\n", maskToken(client.cred.token), client.cred.source)
+
+			user, err := currentUser(client)
+			if err != nil {
+				return err
+			}
+			if kind := accessTokenKind(user.Username); kind != "" {
+				fmt.Printf("user:    @%s (%s access token)\n", user.Username, kind)
+			} else {
+				fmt.Printf("user:    @%s\n", user.Username)
+			}
+
+			if client.cred.job {
+				// Job tokens have fixed permissions and can't be introspected
+				return nil
+			}
+
+			token, err := tokenInfo(client)
+			if err != nil {
+				fmt.Fprintf(stderr, "warning: could not read token details: %v\n", err)
+				return nil
+			}
+			fmt.Printf("scopes:  %s\n", strings.Join(token.Scopes, ", "))
+			if client.cred.oauth {
+				fmt.Printf("expires: refreshed automatically\n")
+			} else {
+				fmt.Printf("expires: %s\n", describeExpiry(token.ExpiresAt))
+				warnIfExpiring(token.expiry())
+			}
+
+			if missing := token.missingScopes(*assign); len(missing) > 0 {
+				return fmt.Errorf("token lacks the %s scope (%s)", strings.Join(missing, " and "), scopeReasons[missing[0]])
+			}
+			return nil
+		},
+	}
+}
+
+func newAuthRotateCommand() *command {
+	fs := newFlagSet("rotate")
+	host := &options.host // the global --host flag
+	expires := fs.String("expires", "", "Lifetime of the new token, e.g. 30d (default: GitLab's default of one week)")
+
+	return &command{
+		name:    "rotate",
+		summary: "Replace the stored token with a fresh one",
+		help: `Rotate the stored personal access token: GitLab revokes it and issues a
+replacement with the same scopes, which is stored where the old one was (a
+token from ~/.gitlab_pat moves to the credentials file). Tokens from the
+environment, token_command, ~/.netrc or glab are not managed by
+gitlab-reviewer and have to be rotated at their source. Requires GitLab 16.10
+or newer.`,
+		flags: fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if *host == "" {
+				*host = remoteHost()
+			}
+
+			var lifetime time.Duration
+			if *expires != "" {
+				d, err := parseDuration(*expires)
+				if err != nil {
+					return fmt.Errorf("invalid -expires: %w", err)
+				}
+				lifetime = d
+			}
+
+			cred, err := findToken(*host)
+			if err != nil {
+				return err
+			}
+			if cred.store == "" || cred.oauth {
+				return fmt.Errorf("the token from %s is not stored by gitlab-reviewer; rotate it there", cred.source)
+			}
+
+			rotated, err := rotateToken(newGitLabClientFor(&gitlabProject{Host: *host}, cred), lifetime)
+			if err != nil {
+				return err
+			}
+
+			if cred.store == storeKeyring {
+				err = keyringSet(*host, rotated.Token)
+			} else {
+				err = setCredentialsToken(*host, rotated.Token, rotated.expiry())
+				if err == nil && cred.source == "~/.gitlab_pat" {
+					// The old token is revoked, so the file is useless now
+					if path, pathErr := patPath(); pathErr == nil {
+						err = os.Remove(path)
+					}
+				}
+			}
+			if err != nil {
+				// The old token is gone; don't lose the new one as well
+				fmt.Printf("%s\n", rotated.Token)
+				return fmt.Errorf("could not store the new token (printed above): %w", err)
+			}
+
+			fmt.Fprintf(stderr, "Rotated token for %s, new token expires %s\n", *host, describeExpiry(rotated.ExpiresAt))
+			return nil
+		},
+	}
+}
+
+// rotatedToken is the response of the token rotation endpoint.
+type rotatedToken struct {
+	apiToken
+	Token string `json:"token"`
+}
+
+// rotateToken revokes the token the client authenticates with and returns
+// its replacement. A zero lifetime leaves the expiry to GitLab.
+func rotateToken(client *gitlabClient, lifetime time.Duration) (*rotatedToken, error) {
+	if err := client.require(featureTokenRotation); err != nil {
+		return nil, err
+	}
+
+	body := map[string]any{}
+	if lifetime > 0 {
+		body["expires_at"] = time.Now().Add(lifetime).Format(time.DateOnly)
+	}
+
+	var rotated rotatedToken
+	if err := client.post("/personal_access_tokens/self/rotate", body, &rotated); err != nil {
+		return nil, fmt.Errorf("rotating token: %w", err)
+	}
+	return &rotated, nil
+}
+
+func newAuthLogoutCommand() *command {
+	fs := newFlagSet("logout")
+	host := &options.host // the global --host flag
+
+	return &command{
+		name:    "logout",
+		summary: "Remove the stored token",
+		help: `Remove the token for the host from the credentials file and the system
+keyring, along with the legacy ~/.gitlab_pat if that holds the token used
+for the host: it serves other hosts too.`,
+		flags: fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if *host == "" {
+				*host = remoteHost()
+			}
+
+			// ~/.gitlab_pat serves every host, so it only goes if it holds
+			// the token used for this one
+			resolved, _ := findToken(*host)
+
+			removed, err := deleteCredentialsToken(*host)
+			if err != nil {
+				return err
+			}
+			if removed {
+				fmt.Fprintf(stderr, "removed token for %s from the credentials file\n", *host)
+			}
+
+			if resolved != nil {
+				removedPAT, err := removePATIf(resolved.token)
+				if err != nil {
+					return err
+				}
+				if removedPAT {
+					fmt.Fprintf(stderr, "removed ~/.gitlab_pat\n")
+					removed = true
+				}
+			}
+
+			if _, err := keyringGet(*host); err == nil {
+				if err := keyringDelete(*host); err != nil {
+					return err
+				}
+				fmt.Fprintf(stderr, "removed token for %s from the keyring\n", *host)
+				removed = true
+			}
+
+			if !removed {
+				return fmt.Errorf("not logged in")
+			}
+			return nil
+		},
+	}
+}
+
+// remoteHost returns the GitLab host of the current project (see
+// currentProject). If it cannot be determined, the host of the selected
+// profile or gitlab.com is used.
+func remoteHost() string {
+	if host, _ := pinnedHost(); host != "" {
+		return host
+	}
+	if project, err := currentProject(); err == nil {
+		return project.Host
+	}
+	if host := conf.Profiles[options.profile].Host; host != "" {
+		return host
+	}
+	return "gitlab.com"
+}
+
+// storeToken verifies token against host and saves it in store (storeFile
+// or storeKeyring) if it works.
+func storeToken(host, token, store string) (*apiUser, error) {
+	client := newGitLabClientFor(&gitlabProject{Host: host}, &credential{token: token})
+	user, err := currentUser(client)
+	if err != nil {
+		return nil, fmt.Errorf("token did not work: %w", err)
+	}
+
+	if store == storeKeyring {
+		if err := keyringSet(host, token); err != nil {
+			return nil, err
+		}
+		// Don't leave plaintext copies behind: the credentials file would
+		// take precedence, and ~/.gitlab_pat, which may serve other hosts,
+		// goes only if it holds this very token
+		if _, err := deleteCredentialsToken(host); err != nil {
+			return nil, err
+		}
+		if _, err := removePATIf(token); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// Remember the expiry so that we can warn about it without asking the
+	// API on every run
+	var expiresAt time.Time
+	if info, err := tokenInfo(client); err == nil {
+		expiresAt = info.expiry()
+	}
+
+	if err := setCredentialsToken(host, token, expiresAt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// reauthState makes sure we ask for a new token at most once per run, also
+// when concurrent requests are rejected together: the first one asks while
+// the others wait, and then they all retry with the new token.
+var reauthState struct {
+	sync.Mutex
+	attempted bool
+	cred      *credential // the new token, if one was entered
+}
+
+// reauth asks for a new token after the API rejected the credential
+// rejected, stores it and swaps it into the client. It reports whether the
+// request should be retried, which is never the case without a terminal.
+// Tokens being checked before they are stored (without a source) are
+// simply reported as rejected.
+func (c *gitlabClient) reauth(rejected *credential) bool {
+	if rejected.source == "" || rejected.job || rejected.oauth {
+		return false
+	}
+	reauthState.Lock()
+	defer reauthState.Unlock()
+	if reauthState.cred != nil {
+		if reauthState.cred == rejected {
+			return false
+		}
+		c.setCredential(reauthState.cred)
+		return true
+	}
+	if reauthState.attempted || !isTerminal(os.Stdin) {
+		return false
+	}
+	reauthState.attempted = true
+
+	host := c.project.Host
+	tokenURL := tokenCreationURL(host)
+	fmt.Fprintf(stderr, "The token from %s was rejected by %s. Create a new one at:\n  %s\n\n", rejected.source, host, tokenURL)
+
+	if open, err := confirm("Open this page in your browser?", false); err == nil && open {
+		if err := openBrowser(tokenURL); err != nil {
+			fmt.Fprintf(stderr, "warning: could not open browser: %v\n", err)
+		}
+	}
+
+	token, err := promptSecret("Paste a new token (empty to give up): ")
+	if err != nil || token == "" {
+		return false
+	}
+
+	store := rejected.store
+	if store == "" {
+		store = conf.tokenStore()
+	}
+	user, err := storeToken(host, token, store)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return false
+	}
+
+	fmt.Fprintf(stderr, "Logged in to %s as @%s.\n", host, user.Username)
+	if next, err := findToken(host); err == nil && next.token != token {
+		fmt.Fprintf(stderr, "warning: %s takes precedence over the stored token; update it as well\n", next.source)
+	}
+
+	addSecret(token)
+	reauthState.cred = &credential{token: token, source: "re-entered token", store: store}
+	c.setCredential(reauthState.cred)
+	return true
+}
+
+// oauthLoginAndStore logs in to host with the device flow and saves the
+// resulting token in the credentials file.
+func oauthLoginAndStore(host, clientID string) (*apiUser, error) {
+	token, err := oauthLogin(host, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := currentUser(newGitLabClientFor(&gitlabProject{Host: host}, &credential{token: token.AccessToken, oauth: true}))
+	if err != nil {
+		return nil, fmt.Errorf("token did not work: %w", err)
+	}
+
+	if err := setCredentials(host, token.credentials(clientID)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// patPath returns the location of the legacy, host-agnostic token file.
+func patPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".gitlab_pat"), nil
+}
+
+// credential is an access token together with where it was found.
+type credential struct {
+	token  string
+	source string // e.g. "GITLAB_TOKEN" or "~/.gitlab_pat"
+	job    bool   // CI job token, sent as JOB-TOKEN instead of PRIVATE-TOKEN
+	oauth  bool   // OAuth access token, sent as a bearer token
+
+	store     string    // storeFile or storeKeyring if we manage the token, for auth rotate
+	expiresAt time.Time // zero if unknown
+}
+
+// header returns the HTTP header the token is sent in and its value.
+func (c *credential) header() (name, value string) {
+	switch {
+	case c.job:
+		return "JOB-TOKEN", c.token
+	case c.oauth:
+		return "Authorization", "Bearer " + c.token
+	}
+	return "PRIVATE-TOKEN", c.token
+}
+
+// Token stores auth login can write to.
+const (
+	storeFile    = "file"
+	storeKeyring = "keyring"
+)
+
+// keyringService is the service name tokens are stored under in the
+// system keyring.
+const keyringService = "gitlab-reviewer"
+
+// tokenEnvVars are checked, in order, before any stored token.
+var tokenEnvVars = []string{"GITLAB_TOKEN", "GITLAB_PRIVATE_TOKEN"}
+
+// findToken returns the token to use for API requests to host.
+func findToken(host string) (*credential, error) {
+	for _, name := range tokenEnvVars {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			return &credential{token: token, source: "$" + name}, nil
+		}
+	}
+
+	// Inside a GitLab CI job, the job token works for its own instance
+	if token := os.Getenv("CI_JOB_TOKEN"); token != "" {
+		ciHost := os.Getenv("CI_SERVER_HOST")
+		if port := os.Getenv("CI_SERVER_PORT"); ciHost != "" && host != ciHost && port != "" {
+			ciHost += ":" + port
+		}
+		if ciHost == "" || ciHost == host {
+			return &credential{token: token, source: "$CI_JOB_TOKEN", job: true}, nil
+		}
+	}
+
+	// An explicitly configured command must work; don't silently fall back
+	if command := conf.tokenCommand(host); command != "" {
+		token, err := runTokenCommand(command, host)
+		if err != nil {
+			return nil, err
+		}
+		return &credential{token: token, source: "token_command"}, nil
+	}
+
+	if cred, err := readCredentialsToken(host); err == nil {
+		return cred, nil
+	} else if !errors.Is(err, errNoToken) {
+		return nil, err
+	}
+
+	if keyringToken, keyringErr := keyringGet(host); keyringErr == nil {
+		return &credential{token: keyringToken, source: "system keyring", store: storeKeyring}, nil
+	}
+
+	if netrcToken, netrcErr := readNetrcToken(host); netrcErr == nil {
+		return &credential{token: netrcToken, source: "~/.netrc"}, nil
+	}
+
+	// Reuse the token of an authenticated glab CLI, if any
+	if glabToken, glabErr := readGlabToken(host); glabErr == nil {
+		return &credential{token: glabToken, source: "glab config"}, nil
+	}
+
+	// The older ~/.gitlab_pat isn't tied to a host, so it only fills in for
+	// hosts without a token of their own
+	token, patErr := readPAT()
+	if patErr == nil {
+		return &credential{token: token, source: "~/.gitlab_pat", store: storeFile}, nil
+	}
+
+	if conf.RemoteCredentials {
+		if cred := remoteToken(host); cred != nil {
+			return cred, nil
+		}
+	}
+
+	if !errors.Is(patErr, os.ErrNotExist) {
+		return nil, patErr
+	}
+	return nil, fmt.Errorf("no token for %s: %w", host, errNoToken)
+}
+
+// remoteToken returns the token embedded in the URL of the git remote, if
+// it points at host. In CI, the user gitlab-ci-token marks a job token.
+func remoteToken(host string) *credential {
+	remoteURL, err := getRemoteURL()
+	if err != nil {
+		return nil
+	}
+	project, err := parseGitLabRemote(remoteURL)
+	if err != nil || project.Host != host {
+		return nil
+	}
+	user, token := remoteCredentials(remoteURL)
+	if token == "" {
+		return nil
+	}
+	return &credential{token: token, source: "git remote URL", job: user == "gitlab-ci-token"}
+}
+
+// errNoToken is returned by findToken when no token is configured.
+var errNoToken = errors.New("run \"gitlab-reviewer auth login\" to store one")
+
+// readPAT reads the GitLab personal access token from ~/.gitlab_pat.
+func readPAT() (string, error) {
+	path, err := patPath()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read ~/.gitlab_pat: %w", err)
+	}
+	if err := checkPrivate(path); err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("~/.gitlab_pat is empty")
+	}
+
+	return token, nil
+}
+
+// removePATIf removes ~/.gitlab_pat if it holds token, and reports
+// whether it did.
+func removePATIf(token string) (bool, error) {
+	if pat, err := readPAT(); err != nil || pat != token {
+		return false, nil
+	}
+	path, err := patPath()
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// maskToken hides all but the prefix and the last four characters.
+func maskToken(token string) string {
+	prefix := ""
+	if i := strings.LastIndex(token, "-"); i >= 0 && i < 12 {
+		prefix, token = token[:i+1], token[i+1:]
+	}
+	if len(token) <= 4 {
+		return prefix + "****"
+	}
+	return prefix + "****" + token[len(token)-4:]
+}
+
+// describeExpiry renders a YYYY-MM-DD expiry date with the days left.
+func describeExpiry(expiresAt string) string {
+	if expiresAt == "" {
+		return "never"
+	}
+
+	t, err := time.Parse(time.DateOnly, expiresAt)
+	if err != nil {
+		return expiresAt
+	}
+
+	days := int(time.Until(t).Hours() / 24)
+	if days < 0 {
+		return fmt.Sprintf("%s (expired)", expiresAt)
+	}
+	return fmt.Sprintf("%s (%d days)", expiresAt, days)
+}
+
+func newWhoamiCommand() *command {
+	fs := newFlagSet("whoami")
+	jsonOut := fs.Bool("json", conf.jsonOutput(), "Output as JSON")
+
+	return &command{
+		name:    "whoami",
+		summary: "Show the user the access token belongs to",
+		flags:   fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			client, err := newGitLabClient()
+			if err != nil {
+				return err
+			}
+
+			user, err := currentUser(client)
+			if err != nil {
+				return err
+			}
+
+			// Scopes are informational; older instances lack the endpoint
+			scopes := []string{}
+			if token, err := tokenInfo(client); err == nil {
+				scopes = token.Scopes
+			} else {
+				fmt.Fprintf(stderr, "warning: could not read token scopes: %v\n", err)
+			}
+
+			if *jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(map[string]any{
+					"id":       user.ID,
+					"username": user.Username,
+					"name":     user.Name,
+					"host":     client.host,
+					"scopes":   scopes,
+				})
+			}
+
+			fmt.Printf("username: %s\n", user.Username)
+			fmt.Printf("id:       %d\n", user.ID)
+			fmt.Printf("name:     %s\n", user.Name)
+			fmt.Printf("host:     %s\n", client.host)
+			fmt.Printf("scopes:   %s\n", strings.Join(scopes, ", "))
+			return nil
+		},
+	}
+}
+
+// currentUser returns the user the client's token belongs to.
+func currentUser(client *gitlabClient) (*apiUser, error) {
+	var user apiUser
+	if err := client.get("/user", nil, &user); err != nil {
+		return nil, fmt.Errorf("fetching current user: %w", err)
+	}
+	return &user, nil
+}
+
+// apiToken represents the relevant fields of a personal access token as
+// returned by the token introspection endpoint.
+type apiToken struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Active    bool     `json:"active"`
+	Revoked   bool     `json:"revoked"`
+	ExpiresAt string   `json:"expires_at"` // YYYY-MM-DD, empty if it never expires
+}
+
+// tokenInfo returns details about the token the client authenticates with.
+func tokenInfo(client *gitlabClient) (*apiToken, error) {
+	if client.cred.oauth {
+		return oauthTokenInfo(client)
+	}
+
+	if err := client.require(featureTokenInfo); err != nil {
+		return nil, err
+	}
+
+	var token apiToken
+	if err := client.get("/personal_access_tokens/self", nil, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// expiry returns when t expires, or the zero time if it never does.
+func (t *apiToken) expiry() time.Time {
+	expiresAt, err := time.Parse(time.DateOnly, t.ExpiresAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return expiresAt
+}
+
+// expiryWarned makes sure the expiry warning is printed only once per run.
+var expiryWarned bool
+
+// warnIfExpiring warns when a token expiring at expiresAt has less than
+// token_expiry_warning left.
+func warnIfExpiring(expiresAt time.Time) {
+	if expiresAt.IsZero() || expiryWarned {
+		return
+	}
+
+	left := time.Until(expiresAt)
+	if left > conf.tokenExpiryWarning() {
+		return
+	}
+	expiryWarned = true
+
+	if left < 0 {
+		fmt.Fprintf(stderr, "warning: token expired on %s; run \"gitlab-reviewer auth login\"\n", expiresAt.Format(time.DateOnly))
+		return
+	}
+	fmt.Fprintf(stderr, "warning: token expires in %d days; run \"gitlab-reviewer auth rotate\"\n", int(left.Hours()/24))
+}
+
+func (t *apiToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeReasons explains what each required scope is needed for.
+var scopeReasons = map[string]string{
+	"read_api": "needed to list members",
+	"api":      "needed to assign reviewers",
+}
+
+// missingScopes returns the scopes t lacks to list members and, with
+// assign, to assign reviewers. The api scope includes read_api.
+func (t *apiToken) missingScopes(assign bool) []string {
+	switch {
+	case t.hasScope("api"):
+		return nil
+	case assign:
+		return []string{"api"}
+	case !t.hasScope("read_api"):
+		return []string{"read_api"}
+	}
+	return nil
+}
+
+// tokenCreationURL links to the page for creating a personal access token
+// on host, with the name and scopes prefilled.
+func tokenCreationURL(host string) string {
+	params := url.Values{"name": {"gitlab-reviewer"}, "scopes": {"api"}}
+	return fmt.Sprintf("https://%s/-/user_settings/personal_access_tokens?%s", conf.apiHost(&gitlabProject{Host: host}), params.Encode())
+}
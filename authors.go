@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// authorsFile is the file in the cache directory that maps "<host> <email>"
+// of git authors to the GitLab user found for them.
+const authorsFile = "authors.json"
+
+// maxAuthorSearches caps the number of git authors looked up on GitLab in
+// one run, so that the first run in a large repository doesn't hammer the
+// users API. The rest are looked up on later runs.
+const maxAuthorSearches = 50
+
+// cachedAuthor is an entry of authorsFile. Authors without a GitLab user
+// have an empty Username, and are searched for again once the entry is
+// older than the cache TTL.
+type cachedAuthor struct {
+	ID        int       `json:"id,omitempty"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// resolveAuthors returns members with the GitLab users of git authors (the
+// members without a username, but with an email address) filled in from
+// authorsFile, and with search, from the users API for the authors not
+// found there. Failures only cost the usernames, with a warning.
+//
+// Without search no requests are made, for when the API has just failed
+// and we fall back to git log: searching would only add more failures.
+func resolveAuthors(members []Member, search bool) []Member {
+	project, err := currentProject()
+	if err != nil {
+		verbosef("not looking up git authors: %v", err)
+		return members
+	}
+	host := conf.apiHost(project)
+
+	members = slices.Clone(members)
+	cached, _ := readCacheMap[cachedAuthor](authorsFile)
+	key := func(m Member) string { return host + " " + strings.ToLower(m.Email) }
+	fill := func(m *Member, a cachedAuthor) {
+		if a.Username != "" {
+			m.ID, m.Username, m.Name = a.ID, a.Username, a.Name
+		}
+	}
+
+	var todo []int
+	for i, m := range members {
+		if m.Username != "" || m.Email == "" {
+			continue
+		}
+		if a, ok := cached[key(m)]; ok && (a.Username != "" || time.Since(a.CheckedAt) < conf.cacheTTL()) {
+			fill(&members[i], a)
+			continue
+		}
+		todo = append(todo, i)
+	}
+	if !search || len(todo) == 0 {
+		return members
+	}
+
+	client, err := newGitLabClient()
+	if err == nil {
+		err = checkBreaker(client.project.Host)
+	}
+	if err != nil {
+		verbosef("not looking up git authors on GitLab: %v", err)
+		return members
+	}
+	if len(todo) > maxAuthorSearches {
+		verbosef("looking up %d of %d git authors on GitLab, the rest next time", maxAuthorSearches, len(todo))
+		todo = todo[:maxAuthorSearches]
+	}
+
+	found := make([]*cachedAuthor, len(todo))
+	err = batch(len(todo), func(k int) error {
+		u, err := searchAuthor(client, members[todo[k]])
+		if err != nil {
+			return err
+		}
+		found[k] = &cachedAuthor{CheckedAt: time.Now()}
+		if u != nil {
+			found[k].ID, found[k].Username, found[k].Name = u.ID, u.Username, u.Name
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not look up git authors on GitLab: %v\n", err)
+	}
+
+	err = updateCacheMap(authorsFile, func(authors map[string]cachedAuthor) {
+		for k, i := range todo {
+			if found[k] != nil {
+				fill(&members[i], *found[k])
+				authors[key(members[i])] = *found[k]
+			}
+		}
+	})
+	if err != nil {
+		verbosef("could not cache git authors: %v", err)
+	}
+	return members
+}
+
+// searchAuthor searches the users API for the git author m, by email and
+// then by name. It returns nil if there is no clear match.
+func searchAuthor(client *gitlabClient, m Member) (*apiUser, error) {
+	for _, term := range []string{m.Email, m.Name} {
+		var users []apiUser
+		if err := client.get("/users", url.Values{"search": {term}}, &users); err != nil {
+			return nil, fmt.Errorf("searching for %s: %w", term, err)
+		}
+		// GitLab only matches emails that are public (or, for
+		// administrators, any), so a single hit is the author
+		if term == m.Email && len(users) == 1 {
+			return &users[0], nil
+		}
+		if u := pickAuthor(users, m); u != nil {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+// pickAuthor returns the one user among users with the git author's name
+// (ignoring case, spaces and punctuation) or with the local part of their
+// email address as username, or nil if there isn't exactly one.
+func pickAuthor(users []apiUser, m Member) *apiUser {
+	local, _, _ := strings.Cut(m.Email, "@")
+	var match *apiUser
+	for i, u := range users {
+		if looseName(u.Name) == looseName(m.Name) || (local != "" && strings.EqualFold(u.Username, local)) {
+			if match != nil {
+				return nil
+			}
+			match = &users[i]
+		}
+	}
+	return match
+}
+
+// looseName returns name in lower case with everything but letters and
+// digits left out, e.g. "jdoe" for "J. Doe".
+func looseName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
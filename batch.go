@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// batchWorkers bounds how many calls a batch makes at once.
+const batchWorkers = 4
+
+// batch calls fn(i) for every i from 0 to n-1, with up to batchWorkers calls
+// at once, for lookups that take one API request per item. fn stores its
+// result at index i. The requests still wait for the rate limit (see
+// rateLimit), so a large batch slows down rather than exhausting it.
+//
+// Once a call fails or the run is interrupted, no new calls are started.
+// The error of the first failed item is returned.
+func batch(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, batchWorkers)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+
+	for i := range n {
+		sem <- struct{}{}
+		if failed.Load() || ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				errs[i] = err
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
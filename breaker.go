@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// When the API of a host failed breakerThreshold times in a row, the last
+// time less than breakerCooldown ago, it is not tried again until the
+// cooldown is over. A shell prompt or editor running the tool while the VPN
+// is down then gets the cached members straight away, instead of after
+// every request timed out.
+const (
+	breakerThreshold = 3
+	breakerCooldown  = 2 * time.Minute
+)
+
+// failuresFile is the file in the cache directory that records the recent
+// API failures per host.
+const failuresFile = "failures.json"
+
+// hostFailures is an entry of failuresFile.
+type hostFailures struct {
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+	Error string    `json:"error"`
+}
+
+// checkBreaker returns an error if the API of host should not be tried
+// because it has been failing.
+func checkBreaker(host string) error {
+	failures, _ := readCacheMap[hostFailures](failuresFile)
+	f, ok := failures[host]
+	if !ok || f.Count < breakerThreshold {
+		return nil
+	}
+	since := time.Since(f.Last)
+	if since >= breakerCooldown {
+		return nil
+	}
+	return fmt.Errorf("skipped, as %s failed %d times in a row, the last time %s ago (%s); pass -refresh to try anyway",
+		host, f.Count, since.Round(time.Second), f.Error)
+}
+
+// recordAPIResult counts err, the result of fetching from the API of host,
+// towards the failures of host if it means the API is unreachable or down.
+// Any other result shows the API is up and resets the count.
+func recordAPIResult(host string, err error) {
+	if err != nil && !isOutage(err) {
+		return
+	}
+
+	updateErr := updateCacheMap(failuresFile, func(failures map[string]hostFailures) {
+		if err == nil {
+			delete(failures, host)
+			return
+		}
+		f := failures[host]
+		failures[host] = hostFailures{Count: f.Count + 1, Last: time.Now(), Error: err.Error()}
+	})
+	if updateErr != nil {
+		verbosef("could not record the API result: %v", updateErr)
+	}
+}
+
+// isOutage reports whether err means the API could not be reached or is
+// failing: a network error, a timeout or a server error. Errors that retrying
+// can't fix, like a rejected token or invalid proxy settings, don't count.
+func isOutage(err error) bool {
+	if ctx.Err() != nil || errors.Is(err, errHTTPSettings) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.status >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultCacheTTL = 24 * time.Hour
+
+func newCacheCommand() *command {
+	return &command{
+		name:    "cache",
+		summary: "Inspect and manage the member cache",
+		help: `Inspect and manage the member cache of the current project. Members are
+cached in the user cache directory for 24 hours, or the time set with --ttl,
+$GITLAB_REVIEWER_CACHE_TTL or cache_ttl in the config file.`,
+		commands: []*command{
+			newCacheShowCommand(),
+			newCacheStatusCommand(),
+			newCachePathCommand(),
+			newCacheRefreshCommand(),
+			newCacheClearCommand(),
+		},
+	}
+}
+
+func newCacheShowCommand() *command {
+	fs := newFlagSet("show")
+	jsonOut := fs.Bool("json", conf.jsonOutput(), "Output as JSON instead of TSV")
+
+	return &command{
+		name:    "show",
+		summary: "Print the cached members, even if stale",
+		flags:   fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			path, err := getCachePath()
+			if err != nil {
+				return err
+			}
+
+			info, err := os.Stat(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no cache for this project (%s)", path)
+			} else if err != nil {
+				return err
+			}
+
+			members, err := readCacheIgnoreTTL(path)
+			if err != nil {
+				return err
+			}
+
+			age := time.Since(info.ModTime()).Round(time.Second)
+			status := "fresh"
+			if age > conf.cacheTTL() {
+				status = "stale"
+			}
+			fmt.Fprintf(stderr, "%s: %d members, updated %s ago (%s)\n", path, len(members), age, status)
+
+			return printMembers(os.Stdout, members, *jsonOut, conf.fields())
+		},
+	}
+}
+
+// cacheStatus describes the cached member list of a project.
+type cacheStatus struct {
+	Path      string    `json:"path"`
+	Members   int       `json:"members"`
+	Host      string    `json:"host,omitempty"` // from the members' web URLs
+	UpdatedAt time.Time `json:"updated_at"`
+	Stale     bool      `json:"stale"`
+}
+
+func newCacheStatusCommand() *command {
+	fs := newFlagSet("status")
+	jsonOut := fs.Bool("json", conf.jsonOutput(), "Output as JSON instead of TSV")
+
+	return &command{
+		name:    "status",
+		summary: "Summarize the cached member lists of all projects",
+		help: `Print a line for every cached member list, of any project: the file, the
+number of members, how long ago it was updated, whether that is longer
+than the cache TTL (stale) or not (fresh), and the GitLab host the members
+came from. The cache directory and TTL are printed on stderr first.`,
+		flags: fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			dir, err := cacheDir()
+			if err != nil {
+				return err
+			}
+			statuses, err := cacheStatuses(dir)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(stderr, "%s: %d member lists, TTL %s\n", dir, len(statuses), formatDuration(conf.cacheTTL()))
+			if *jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(statuses); err != nil {
+					return fmt.Errorf("encoding json: %w", err)
+				}
+				return nil
+			}
+			for _, s := range statuses {
+				freshness := "fresh"
+				if s.Stale {
+					freshness = "stale"
+				}
+				age := time.Since(s.UpdatedAt).Round(time.Second)
+				fmt.Printf("%s\t%d\t%s\t%s\t%s\n", s.Path, s.Members, age, freshness, s.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// cacheMapFiles are the files in the cache directory kept with
+// readCacheMap and updateCacheMap, as opposed to member lists.
+var cacheMapFiles = []string{authorsFile, failuresFile, projectIDsFile, selfFile, versionsFile}
+
+// cacheStatuses describes the member lists cached in dir, by file name.
+// The files of cacheMapFiles, and any others that aren't member lists, are
+// skipped.
+func cacheStatuses(dir string) ([]cacheStatus, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []cacheStatus{}
+	for _, path := range paths {
+		if slices.Contains(cacheMapFiles, filepath.Base(path)) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		members, err := readCacheIgnoreTTL(path)
+		if err != nil {
+			verbosef("skipping %s: %v", path, err)
+			continue
+		}
+
+		s := cacheStatus{
+			Path:      path,
+			Members:   len(members),
+			UpdatedAt: info.ModTime(),
+			Stale:     time.Since(info.ModTime()) > conf.cacheTTL(),
+		}
+		for _, m := range members {
+			if u, err := url.Parse(m.WebURL); err == nil && u.Host != "" {
+				s.Host = u.Host
+				break
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func newCachePathCommand() *command {
+	return &command{
+		name:    "path",
+		summary: "Print the cache file of the current project",
+		help: `Print the file the members of the current project are cached in, whether
+it exists yet or not. It depends on the project and on --upstream,
+--include-shared-groups and --group, like the cache itself.`,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			path, err := getCachePath()
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+			return nil
+		},
+	}
+}
+
+func newCacheRefreshCommand() *command {
+	return &command{
+		name:    "refresh",
+		summary: "Fetch members from the GitLab API and update the cache",
+		help: `Fetch members from the GitLab API and update the cache. Unlike
+"members -refresh", this fails instead of falling back to stale data.`,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			path, err := getCachePath()
+			if err != nil {
+				return err
+			}
+
+			members, err := refreshFromGitLab(path)
+			if members != nil && err != nil {
+				return fmt.Errorf("writing cache: %w", err)
+			} else if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(stderr, "cached %d members in %s\n", len(members), path)
+			return nil
+		},
+	}
+}
+
+func newCacheClearCommand() *command {
+	fs := newFlagSet("clear")
+	all := fs.Bool("all", false, "Delete everything cached: the members of every project, and the cached project IDs, usernames, GitLab versions and API failures")
+
+	return &command{
+		name:    "clear",
+		summary: "Delete the cache of the current project",
+		help: `Delete the cached members of the current project, so that the next run
+fetches them from GitLab. With -all, delete everything gitlab-reviewer has
+cached, for every project and host; this works outside a repository too.
+Only files gitlab-reviewer created are deleted, so a --cache-dir shared with
+other programs keeps theirs.`,
+		flags: fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if *all {
+				dir, err := cacheDir()
+				if err != nil {
+					return err
+				}
+				removed, err := clearCacheDir(dir)
+				if err != nil {
+					return err
+				}
+				if cacheDirOverride() == "" {
+					// Our own directory; this fails if anything is left
+					os.Remove(dir)
+				}
+				fmt.Fprintf(stderr, "removed %d files from %s\n", removed, dir)
+				return nil
+			}
+
+			path, err := getCachePath()
+			if err != nil {
+				return err
+			}
+
+			for _, p := range []string{path, etagPath(path)} {
+				if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+
+			fmt.Fprintf(stderr, "removed %s\n", path)
+			return nil
+		},
+	}
+}
+
+func getCachePath() (string, error) {
+	project, err := currentProject()
+	if err != nil {
+		remoteURL, err := getRemoteURL()
+		if err != nil {
+			return "", err
+		}
+
+		// Non-GitLab remote: use a sanitized version of the URL
+		sanitized := strings.NewReplacer("/", "-", ":", "-", "@", "-", ".", "-").Replace(stripUserinfo(remoteURL))
+		project = &gitlabProject{Path: sanitized}
+	}
+
+	// Turn "researchable/general/my-project" into "researchable-general-my-project"
+	name := safeFileName(strings.ReplaceAll(project.Path, "/", "-"))
+	if options.upstream {
+		// The members of the project the fork was made from
+		name += "-upstream"
+	}
+	if options.sharedGroups {
+		name += "-shared"
+	}
+	if project.Group {
+		name += "-group"
+	}
+	filename := name + ".json"
+
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filename), nil
+}
+
+// cacheDir returns the directory the caches are kept in: the --cache-dir
+// flag, $GITLAB_REVIEWER_CACHE_DIR, or gitlab-reviewer in the user cache
+// directory.
+func cacheDir() (string, error) {
+	if dir := cacheDirOverride(); dir != "" {
+		return filepath.Abs(dir)
+	}
+
+	// %LocalAppData% on Windows, ~/Library/Caches on macOS, $XDG_CACHE_HOME
+	// or ~/.cache elsewhere
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		home, homeErr := os.UserHomeDir()
+		if homeErr != nil {
+			return "", fmt.Errorf("could not determine cache directory: %w", err)
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(dir, "gitlab-reviewer"), nil
+}
+
+// cacheDirOverride returns the cache directory given with --cache-dir or
+// $GITLAB_REVIEWER_CACHE_DIR, or "" if neither is set. Such a directory
+// may hold other files too.
+func cacheDirOverride() string {
+	return cmp.Or(options.cacheDir, os.Getenv("GITLAB_REVIEWER_CACHE_DIR"))
+}
+
+// clearCacheDir deletes the files gitlab-reviewer keeps in dir: the member
+// lists with their ETags, the cache maps, their lock files and any
+// temporary files left by an interrupted write. Other files are left
+// alone, since dir may be shared (see cacheDirOverride). It returns the
+// number of files deleted.
+func clearCacheDir(dir string) (int, error) {
+	statuses, err := cacheStatuses(dir)
+	if err != nil {
+		return 0, err
+	}
+	var files []string
+	for _, name := range cacheMapFiles {
+		files = append(files, filepath.Join(dir, name))
+	}
+	for _, s := range statuses {
+		files = append(files, s.Path, etagPath(s.Path))
+	}
+	for _, path := range slices.Clone(files) {
+		files = append(files, path+".lock")
+		temps, _ := filepath.Glob(filepath.Join(dir, "."+filepath.Base(path)+".*.tmp"))
+		files = append(files, temps...)
+	}
+
+	removed := 0
+	for _, path := range files {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// projectIDsFile is the file in the cache directory that maps
+// "<host>/<path>" to the numeric project ID.
+const projectIDsFile = "project-ids.json"
+
+// cachedProjectID returns the cached ID of project, or 0 if it is not known.
+func cachedProjectID(project *gitlabProject) int {
+	ids, _ := readCacheMap[int](projectIDsFile)
+	return ids[project.Host+"/"+project.Path]
+}
+
+// cacheProjectID remembers the ID of project.
+func cacheProjectID(project *gitlabProject, id int) error {
+	return updateCacheMap(projectIDsFile, func(ids map[string]int) {
+		ids[project.Host+"/"+project.Path] = id
+	})
+}
+
+// versionsFile is the file in the cache directory that maps API hosts to
+// the GitLab version they run.
+const versionsFile = "versions.json"
+
+// cachedVersion is an entry of versionsFile.
+type cachedVersion struct {
+	Version   string    `json:"version"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// cacheVersion remembers that host runs version.
+func cacheVersion(host, version string) error {
+	return updateCacheMap(versionsFile, func(versions map[string]cachedVersion) {
+		versions[host] = cachedVersion{Version: version, CheckedAt: time.Now()}
+	})
+}
+
+// readCacheMap reads the map kept in the file name in the cache directory.
+func readCacheMap[V any](name string) (map[string]V, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]V
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// updateCacheMap applies update to the map kept in the file name in the
+// cache directory, creating it if needed. The file is locked meanwhile, so
+// that processes updating it at the same time don't lose each other's
+// entries.
+func updateCacheMap[V any](name string, update func(map[string]V)) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := readCacheMap[V](name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if m == nil {
+		m = make(map[string]V)
+	}
+	update(m)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, name), data)
+}
+
+// writeFileAtomic writes data to path by way of a temporary file in the
+// same directory that is renamed into place, so that readers never see a
+// partly written file, even if we are interrupted.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp makes the file private, but caches are not secret
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// safeFileName replaces characters that are not allowed in file names on
+// some platforms (notably Windows) with "-".
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < ' ' {
+			return '-'
+		}
+		return r
+	}, name)
+}
+
+func readCache(path string) ([]Member, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(info.ModTime()) > conf.cacheTTL() {
+		return nil, fmt.Errorf("cache is stale")
+	}
+
+	members, err := readCacheIgnoreTTL(path)
+	if err != nil {
+		return nil, err
+	}
+	if members[0].Membership == "" {
+		// Written by a version that didn't record it
+		return nil, fmt.Errorf("cache lacks memberships")
+	}
+	return members, nil
+}
+
+func readCacheIgnoreTTL(path string) ([]Member, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("parsing cache: %w", err)
+	}
+
+	if len(members) == 0 {
+		return nil, fmt.Errorf("cache is empty")
+	}
+
+	return members, nil
+}
+
+// writeCache writes members to the cache at path, along with the ETags of
+// the pages they were fetched from (if any) for conditional refreshes.
+func writeCache(path string, members []Member, tags []pageTag) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(members, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	return writeETags(path, tags)
+}
+
+// etagPath returns the file the ETags for the cache at path are kept in.
+func etagPath(path string) string {
+	return path + ".etag"
+}
+
+func readETags(path string) ([]pageTag, error) {
+	data, err := os.ReadFile(etagPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []pageTag
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("parsing ETags: %w", err)
+	}
+	return tags, nil
+}
+
+func writeETags(path string, tags []pageTag) error {
+	if len(tags) == 0 {
+		if err := os.Remove(etagPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(tags, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(etagPath(path), data)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a node in the CLI command tree. Leaf commands have a run
+// function; group commands (like "cache") only dispatch to subcommands.
+type command struct {
+	name     string
+	args     string // synopsis of positional arguments, e.g. "<iid>"
+	summary  string // one-line description shown in command lists
+	help     string // longer description shown by -h
+	hidden   bool
+	rawArgs  bool // pass all arguments to run without parsing flags
+	flags    *flag.FlagSet
+	run      func(args []string) error
+	commands []*command
+
+	// Shell completion of flag values (keyed by flag name) and positional
+	// arguments. Candidates are filtered by the typed prefix afterwards.
+	flagValues map[string]func() []string
+	argValues  func(args []string) []string
+}
+
+// newFlagSet creates a flag set for a command. Errors are returned to the
+// caller instead of exiting so that help output is handled in one place.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// lookup returns the direct subcommand with the given name, or nil.
+func (c *command) lookup(name string) *command {
+	for _, sub := range c.commands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// execute parses args for c and runs it, descending into subcommands as
+// needed. path holds the names of the parent commands, used in usage text.
+func (c *command) execute(path []string, args []string) error {
+	path = append(path, c.name)
+
+	if len(c.commands) > 0 && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub := c.lookup(args[0])
+		if sub == nil {
+			return fmt.Errorf("unknown command %q for %q", args[0], strings.Join(path, " "))
+		}
+		return sub.execute(path, args[1:])
+	}
+
+	if c.rawArgs {
+		return c.run(args)
+	}
+
+	if c.flags == nil {
+		c.flags = newFlagSet(c.name)
+	}
+
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			c.usage(os.Stdout, path)
+			return nil
+		}
+		c.usage(stderr, path)
+		return err
+	}
+
+	if c.run == nil {
+		c.usage(stderr, path)
+		if c.flags.NArg() > 0 {
+			return fmt.Errorf("unknown command %q for %q", c.flags.Arg(0), strings.Join(path, " "))
+		}
+		return fmt.Errorf("%q requires a subcommand", strings.Join(path, " "))
+	}
+
+	return c.run(c.flags.Args())
+}
+
+// synopsis returns the one-line invocation summary of c, e.g.
+// "gitlab-reviewer assign [flags] [mr-iid]".
+func (c *command) synopsis(path []string) string {
+	synopsis := strings.Join(path, " ")
+	if len(c.commands) > 0 {
+		synopsis += " <command>"
+	}
+	if c.flags != nil && hasFlags(c.flags) {
+		synopsis += " [flags]"
+	}
+	if c.args != "" {
+		synopsis += " " + c.args
+	}
+	return synopsis
+}
+
+// usage writes the help text for c to w.
+func (c *command) usage(w io.Writer, path []string) {
+	full := strings.Join(path, " ")
+	fmt.Fprintf(w, "usage: %s\n", c.synopsis(path))
+
+	if c.help != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.help))
+	} else if c.summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.summary)
+	}
+
+	if len(c.commands) > 0 {
+		fmt.Fprintf(w, "\nCommands:\n")
+		for _, sub := range c.commands {
+			if sub.hidden {
+				continue
+			}
+			fmt.Fprintf(w, "  %-12s %s\n", sub.name, sub.summary)
+		}
+		fmt.Fprintf(w, "\nRun '%s <command> -h' for details on a command.\n", full)
+	}
+
+	if c.flags != nil && hasFlags(c.flags) {
+		fmt.Fprintf(w, "\nFlags:\n")
+		c.flags.SetOutput(w)
+		c.flags.PrintDefaults()
+		c.flags.SetOutput(io.Discard)
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// newHelpCommand returns a "help" command that prints usage for any command
+// in the tree rooted at root.
+func newHelpCommand(root *command) *command {
+	return &command{
+		name:    "help",
+		args:    "[command...]",
+		summary: "Show help for a command",
+		run: func(args []string) error {
+			c, path := root, []string{root.name}
+			for _, name := range args {
+				sub := c.lookup(name)
+				if sub == nil {
+					return fmt.Errorf("unknown command %q for %q", name, strings.Join(path, " "))
+				}
+				c, path = sub, append(path, name)
+			}
+			c.usage(os.Stdout, path)
+			return nil
+		},
+		argValues: func(args []string) []string {
+			c := root
+			for _, name := range args {
+				if c = c.lookup(name); c == nil {
+					return nil
+				}
+			}
+			var names []string
+			for _, sub := range c.commands {
+				if !sub.hidden {
+					names = append(names, sub.name)
+				}
+			}
+			return names
+		},
+	}
+}
+
+// stringsFlag is a flag that can be repeated, collecting every value.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// noArgs returns an error if a command that takes no positional arguments
+// was given some.
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
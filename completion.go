@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for gitlab-reviewer
+_gitlab_reviewer() {
+    local IFS=$'\n'
+    COMPREPLY=($(gitlab-reviewer __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _gitlab_reviewer gitlab-reviewer
+`
+
+const zshCompletion = `#compdef gitlab-reviewer
+# zsh completion for gitlab-reviewer
+_gitlab_reviewer() {
+    local -a candidates
+    candidates=("${(@f)$(gitlab-reviewer __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    compadd -a candidates
+}
+compdef _gitlab_reviewer gitlab-reviewer
+`
+
+const fishCompletion = `# fish completion for gitlab-reviewer
+complete -c gitlab-reviewer -f -a '(gitlab-reviewer __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+func newCompletionCommand() *command {
+	return &command{
+		name:    "completion",
+		args:    "<bash|zsh|fish>",
+		summary: "Print a shell completion script",
+		help: `Print a completion script for the given shell. Usernames for -reviewer
+are completed from the member cache of the current repository.
+
+  # bash (~/.bashrc)
+  source <(gitlab-reviewer completion bash)
+
+  # zsh (~/.zshrc)
+  source <(gitlab-reviewer completion zsh)
+
+  # fish
+  gitlab-reviewer completion fish > ~/.config/fish/completions/gitlab-reviewer.fish`,
+		run: func(args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected a shell: bash, zsh or fish")
+			}
+
+			script, ok := completionScripts[args[0]]
+			if !ok {
+				return fmt.Errorf("unsupported shell %q (want bash, zsh or fish)", args[0])
+			}
+
+			fmt.Print(script)
+			return nil
+		},
+		argValues: func(args []string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			return []string{"bash", "zsh", "fish"}
+		},
+	}
+}
+
+// newCompleteCommand returns the hidden command the completion scripts
+// call. Its arguments are the words on the command line after the program
+// name, the last one being the word under the cursor.
+func newCompleteCommand(root *command) *command {
+	return &command{
+		name:    "__complete",
+		hidden:  true,
+		rawArgs: true,
+		run: func(args []string) error {
+			if len(args) == 0 {
+				args = []string{""}
+			}
+			for _, candidate := range complete(root, args[:len(args)-1], args[len(args)-1]) {
+				fmt.Println(candidate)
+			}
+			return nil
+		},
+	}
+}
+
+// complete returns the candidates for cur, given the preceding words.
+func complete(root *command, words []string, cur string) []string {
+	c, i := root, 0
+	for i < len(words) && len(c.commands) > 0 && !strings.HasPrefix(words[i], "-") {
+		if c = c.lookup(words[i]); c == nil {
+			return nil
+		}
+		i++
+	}
+	rest := words[i:]
+
+	// Flags without a command apply to the default command
+	if c == root && (len(rest) > 0 || strings.HasPrefix(cur, "-")) {
+		c = root.lookup("members")
+	}
+
+	// bash splits "-reviewer=ali" into "-reviewer", "=" and "ali"
+	if cur == "=" && len(rest) > 0 {
+		cur = ""
+	} else if len(rest) > 1 && rest[len(rest)-1] == "=" {
+		rest = rest[:len(rest)-1]
+	}
+
+	// Value for the previous flag
+	if len(rest) > 0 {
+		if name, ok := valueFlagName(c, rest[len(rest)-1]); ok {
+			return withPrefix(c.flagValueCandidates(name), "", cur)
+		}
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		// Value given inline, as in -reviewer=ali
+		if name, _, ok := strings.Cut(cur, "="); ok {
+			return withPrefix(c.flagValueCandidates(strings.TrimLeft(name, "-")), name+"=", cur)
+		}
+		return withPrefix(c.flagNames(strings.HasPrefix(cur, "--")), "", cur)
+	}
+
+	if len(c.commands) > 0 && len(rest) == 0 {
+		var names []string
+		for _, sub := range c.commands {
+			if !sub.hidden {
+				names = append(names, sub.name)
+			}
+		}
+		return withPrefix(names, "", cur)
+	}
+
+	if c.argValues != nil {
+		return withPrefix(c.argValues(positionalArgs(c, rest)), "", cur)
+	}
+	return nil
+}
+
+// valueFlagName returns the name of the flag in word if it is a flag that
+// takes a separate value (i.e. not a boolean and not written as -f=v).
+func valueFlagName(c *command, word string) (string, bool) {
+	if c.flags == nil || !strings.HasPrefix(word, "-") || strings.Contains(word, "=") {
+		return "", false
+	}
+	name := strings.TrimLeft(word, "-")
+	f := c.flags.Lookup(name)
+	if f == nil || isBoolFlag(f) {
+		return "", false
+	}
+	return name, true
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// positionalArgs drops flags and their values from words.
+func positionalArgs(c *command, words []string) []string {
+	var args []string
+	for i := 0; i < len(words); i++ {
+		if _, ok := valueFlagName(c, words[i]); ok {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(words[i], "-") {
+			args = append(args, words[i])
+		}
+	}
+	return args
+}
+
+func (c *command) flagNames(double bool) []string {
+	if c.flags == nil {
+		return nil
+	}
+
+	dashes := "-"
+	if double {
+		dashes = "--"
+	}
+
+	var names []string
+	c.flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, dashes+f.Name)
+	})
+	return names
+}
+
+func (c *command) flagValueCandidates(name string) []string {
+	if fn, ok := c.flagValues[name]; ok {
+		return fn()
+	}
+	if fn, ok := globalFlagValues[name]; ok {
+		return fn()
+	}
+	return nil
+}
+
+// withPrefix returns the candidates (each prefixed with prefix) that start
+// with cur.
+func withPrefix(candidates []string, prefix, cur string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if candidate = prefix + candidate; strings.HasPrefix(candidate, cur) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// completeUsernames returns the usernames from the member cache of the
+// current repository. It never touches the network so that completion
+// stays fast; a stale cache is good enough.
+func completeUsernames() []string {
+	path, err := getCachePath()
+	if err != nil {
+		return nil
+	}
+
+	members, err := readCacheIgnoreTTL(path)
+	if err != nil {
+		return nil
+	}
+
+	var usernames []string
+	exclude, _ := excludePatterns()
+	for _, m := range filterMembers(members, memberFilter{State: "active", Exclude: exclude}) {
+		if m.Username != "" {
+			usernames = append(usernames, m.Username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func completeAccessLevels() []string {
+	levels := make([]string, 0, len(accessLevels))
+	for name := range accessLevels {
+		levels = append(levels, name)
+	}
+	sort.Slice(levels, func(i, j int) bool { return accessLevels[levels[i]] < accessLevels[levels[j]] })
+	return levels
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contributor is an author in the git history, with their number of
+// commits.
+type contributor struct {
+	name    string
+	email   string
+	commits int
+}
+
+// contributors lists the authors of the non-merge commits reachable from
+// HEAD since conf.gitSince, most commits first, so that people who left long
+// ago don't come up. If nobody committed in that window, it lists those of
+// the whole history instead. Authors are merged as .mailmap says, which git
+// shortlog applies, and beyond that when they share an email address or
+// their names only differ in case, spacing or punctuation. Each keeps the
+// name and email they used most.
+func contributors() ([]contributor, error) {
+	args := []string{"shortlog", "--summary", "--numbered", "--email", "--no-merges"}
+	var out string
+	var err error
+	if since := conf.gitSince(); !since.IsZero() {
+		out, err = gitOutput(append(args, "--since="+since.Format(time.RFC3339), "HEAD")...)
+	}
+	if err == nil && out == "" {
+		out, err = gitOutput(append(args, "HEAD")...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	var list []contributor
+	index := make(map[string]int) // lower-case email or looseName -> index into list
+	for _, line := range splitLines(out) {
+		count, author, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil {
+			continue
+		}
+		name, email, _ := strings.Cut(author, " <")
+		email = strings.TrimSuffix(email, ">")
+
+		keys := []string{"email " + strings.ToLower(email), "name " + looseName(name)}
+		i, ok := index[keys[0]]
+		if !ok {
+			i, ok = index[keys[1]]
+		}
+		if !ok {
+			i = len(list)
+			list = append(list, contributor{name: name, email: email})
+		}
+		list[i].commits += n
+		for _, key := range keys {
+			if _, taken := index[key]; !taken {
+				index[key] = i
+			}
+		}
+	}
+	slices.SortStableFunc(list, func(a, b contributor) int { return cmp.Compare(b.commits, a.commits) })
+	return list, nil
+}
+
+// withContributors returns members with their number of commits in the git
+// history filled in, followed by the contributors who don't match any
+// member (see matchAuthor), with a membership of membershipNone and their
+// GitLab users where resolveAuthors finds them. Contributors that turn out
+// to be a member after all count towards that member.
+func withContributors(members []Member) ([]Member, error) {
+	list, err := contributors()
+	if err != nil {
+		return nil, err
+	}
+
+	members = append([]Member(nil), members...)
+	for _, c := range list {
+		// Non-members are matched too, by name, to merge their emails
+		if i := matchAuthor(members, c.name, c.email); i >= 0 {
+			members[i].Commits += c.commits
+			continue
+		}
+		members = append(members, Member{
+			Name:       c.name,
+			Email:      c.email,
+			Membership: membershipNone,
+			Commits:    c.commits,
+		})
+	}
+	return foldAuthors(resolveAuthors(members, true)), nil
+}
+
+// foldAuthors merges the members with the same GitLab user, by ID or
+// username, into the first of them, adding up their commits.
+func foldAuthors(members []Member) []Member {
+	folded := []Member{}
+	byID := make(map[int]int)
+	byUsername := make(map[string]int)
+	for _, m := range members {
+		i, ok := byID[m.ID]
+		if !ok {
+			i, ok = byUsername[m.Username]
+		}
+		if ok {
+			folded[i].Commits += m.Commits
+			continue
+		}
+		if m.ID != 0 {
+			byID[m.ID] = len(folded)
+		}
+		if m.Username != "" {
+			byUsername[m.Username] = len(folded)
+		}
+		folded = append(folded, m)
+	}
+	return folded
+}
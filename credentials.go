@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// credentialsFile maps GitLab hosts to their tokens. It is stored as
+// credentials.json next to the config file, readable only by the user.
+type credentialsFile struct {
+	Hosts map[string]hostCredentials `json:"hosts"`
+}
+
+// hostCredentials holds the credentials for a single GitLab host. Tokens
+// from an OAuth login also carry what is needed to refresh them.
+type hostCredentials struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+	ClientID     string    `json:"client_id,omitempty"`
+}
+
+// credentialsPath returns the location of the credentials file: the
+// -token-file flag, $GITLAB_REVIEWER_TOKEN_FILE, the token_file setting or
+// credentials.json next to the config file, in that order.
+func credentialsPath() (string, error) {
+	for _, path := range []string{options.tokenFile, os.Getenv("GITLAB_REVIEWER_TOKEN_FILE"), conf.TokenFile} {
+		if path != "" {
+			return expandHome(path)
+		}
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "credentials.json"), nil
+}
+
+// expandHome replaces a leading "~/" (or "~\" on Windows) in path with the
+// home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok && runtime.GOOS == "windows" {
+		rest, ok = strings.CutPrefix(path, `~\`)
+	}
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// loadCredentials reads the credentials file. A missing file yields an
+// empty set of credentials.
+func loadCredentials() (*credentialsFile, error) {
+	creds := &credentialsFile{Hosts: map[string]hostCredentials{}}
+
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return creds, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if creds.Hosts == nil {
+		creds.Hosts = map[string]hostCredentials{}
+	}
+	return creds, nil
+}
+
+func (c *credentialsFile) save() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing credentials: %w", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0o600)
+}
+
+// readCredentialsToken returns the token stored for host, refreshing it
+// first if it is an OAuth token that has (almost) expired.
+func readCredentialsToken(host string) (*credential, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := creds.Hosts[host]
+	if entry.Token == "" {
+		return nil, fmt.Errorf("no token for %s in the credentials file: %w", host, errNoToken)
+	}
+
+	// Writing the file (auth login, logout) fixes the mode, so only
+	// refuse to use the tokens in it
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrivate(path); err != nil {
+		return nil, err
+	}
+	if entry.RefreshToken == "" {
+		return &credential{token: entry.Token, source: "credentials file", store: storeFile, expiresAt: entry.ExpiresAt}, nil
+	}
+
+	if time.Until(entry.ExpiresAt) < time.Minute {
+		token, err := refreshOAuthToken(host, entry.ClientID, entry.RefreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("refreshing OAuth token for %s (run \"gitlab-reviewer auth login -oauth\" again): %w", host, err)
+		}
+		entry = token.credentials(entry.ClientID)
+		creds.Hosts[host] = entry
+		if err := creds.save(); err != nil {
+			return nil, err
+		}
+	}
+	return &credential{token: entry.Token, source: "credentials file (OAuth)", oauth: true}, nil
+}
+
+// checkPrivate refuses token files that other users can access, like SSH
+// does for private keys. File modes don't mean much on Windows, where the
+// check is skipped.
+func checkPrivate(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %04o); fix it with: chmod 600 %s", path, perm, path)
+	}
+	return nil
+}
+
+// setCredentialsToken stores token for host in the credentials file,
+// replacing any OAuth login. expiresAt may be zero if unknown.
+func setCredentialsToken(host, token string, expiresAt time.Time) error {
+	return setCredentials(host, hostCredentials{Token: token, ExpiresAt: expiresAt})
+}
+
+func setCredentials(host string, entry hostCredentials) error {
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+
+	creds.Hosts[host] = entry
+	return creds.save()
+}
+
+// deleteCredentialsToken removes the entry for host, reporting whether
+// there was one.
+func deleteCredentialsToken(host string) (bool, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := creds.Hosts[host]; !ok {
+		return false, nil
+	}
+	delete(creds.Hosts, host)
+	return true, creds.save()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func newDoctorCommand() *command {
+	return &command{
+		name:    "doctor",
+		summary: "Diagnose remote, token, API and cache problems",
+		help: `Check every step the tool depends on (git remote detection, the access
+token, API reachability, token scopes and the cache directory) and print
+what to do about failures. Exits non-zero if any check fails.`,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			d := &doctor{w: &redactingWriter{w: os.Stdout}}
+			d.run()
+			if d.failed {
+				return fmt.Errorf("some checks failed")
+			}
+			return nil
+		},
+	}
+}
+
+// doctor runs the diagnostic checks and reports the results.
+type doctor struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *doctor) pass(name, detail string) {
+	fmt.Fprintf(d.w, "[ok]   %s: %s\n", name, detail)
+}
+
+func (d *doctor) warn(name, detail, hint string) {
+	fmt.Fprintf(d.w, "[warn] %s: %s\n", name, detail)
+	d.hint(hint)
+}
+
+func (d *doctor) fail(name string, err error, hint string) {
+	d.failed = true
+	fmt.Fprintf(d.w, "[FAIL] %s: %v\n", name, err)
+	d.hint(hint)
+}
+
+func (d *doctor) skip(name, reason string) {
+	fmt.Fprintf(d.w, "[skip] %s: %s\n", name, reason)
+}
+
+func (d *doctor) hint(hint string) {
+	for _, line := range strings.Split(hint, "\n") {
+		if line != "" {
+			fmt.Fprintf(d.w, "       %s\n", line)
+		}
+	}
+}
+
+func (d *doctor) run() {
+	project := d.checkRemote()
+	cred := d.checkToken(project)
+
+	if project == nil || cred == nil {
+		d.skip("api", "needs a GitLab remote and a token")
+		d.skip("scopes", "needs a GitLab remote and a token")
+	} else {
+		client := newGitLabClientFor(project, cred)
+		switch {
+		case !d.checkAPI(client):
+			d.skip("scopes", "API is not reachable")
+		case cred.job:
+			d.skip("scopes", "CI job tokens have fixed permissions")
+			d.checkFork(client)
+		default:
+			d.checkScopes(client)
+			d.checkFork(client)
+		}
+	}
+
+	d.checkCacheDir()
+}
+
+func (d *doctor) checkRemote() *gitlabProject {
+	if _, source := pinnedProject(); source != "" {
+		project, _ := currentProject()
+		d.pass("project", project.Host+"/"+project.Path+" (from "+source+")")
+		return project
+	}
+	if ciProject() != nil {
+		project, _ := currentProject()
+		d.pass("project", project.Host+"/"+project.Path+" (from $CI_PROJECT_PATH)")
+		return project
+	}
+
+	remoteURL, err := getRemoteURL()
+	if err != nil {
+		d.fail("git remote", err, "Run inside a git repository with a remote pointing at GitLab, or pick one with --remote.")
+		return nil
+	}
+	switch {
+	case options.superproject && superproject() != "":
+		d.pass("git remote", stripUserinfo(remoteURL)+" (of the superproject)")
+	case superproject() != "":
+		d.pass("git remote", stripUserinfo(remoteURL)+" (of the submodule; pass --superproject for the superproject's)")
+	default:
+		d.pass("git remote", stripUserinfo(remoteURL))
+	}
+
+	project, err := parseGitLabRemote(remoteURL)
+	if err != nil {
+		d.fail("project", err, "Use an SSH (git@host:group/project.git) or HTTPS remote URL.")
+		return nil
+	}
+	if host, source := pinnedHost(); host != "" {
+		project.Host, project.SSHPort = host, ""
+		d.pass("project", project.Host+"/"+project.Path+" (host from "+source+")")
+		return project
+	}
+	d.pass("project", project.Host+"/"+project.Path)
+	return project
+}
+
+func (d *doctor) checkToken(project *gitlabProject) *credential {
+	host := remoteHost()
+	if project != nil {
+		host = project.Host
+	}
+
+	cred, err := findToken(host)
+	if err != nil {
+		d.fail("token", err, fmt.Sprintf("Create a token with the api (or read_api) scope at\n%s\nand run \"gitlab-reviewer auth login\" or set GITLAB_TOKEN.", tokenCreationURL(host)))
+		return nil
+	}
+	d.pass("token", "read from "+cred.source)
+	return cred
+}
+
+func (d *doctor) checkAPI(client *gitlabClient) bool {
+	if client.cred.job {
+		// Job tokens can't access /user; check the project instead
+		if err := client.get(client.projectPath(""), nil, nil); err != nil {
+			d.fail("api", err, "Check that the CI job token has access to this project.")
+			return false
+		}
+		d.pass("api", fmt.Sprintf("authenticated with a CI job token on %s%s", client.host, versionNote(client)))
+		return true
+	}
+
+	user, err := currentUser(client)
+	if err != nil {
+		hint := fmt.Sprintf("Check that https://%s is reachable and that the token is valid and not expired.", client.host)
+		if client.host == client.project.Host && client.project.SSHPort != "" {
+			hint += fmt.Sprintf("\nIf the API is not served at the SSH host, map it with\ngitlab-reviewer config set hosts.%s:%s.api_host <host>", client.project.Host, client.project.SSHPort)
+		}
+		d.fail("api", err, hint)
+		return false
+	}
+	if kind := accessTokenKind(user.Username); kind != "" {
+		d.pass("api", fmt.Sprintf("authenticated with a %s access token (@%s) on %s%s", kind, user.Username, client.host, versionNote(client)))
+		return true
+	}
+	d.pass("api", fmt.Sprintf("authenticated as @%s on %s%s", user.Username, client.host, versionNote(client)))
+	return true
+}
+
+// versionNote returns " (GitLab <version>)" for the instance of client, or
+// "" if its version is unknown.
+func versionNote(client *gitlabClient) string {
+	if v, ok := client.version(); ok {
+		return fmt.Sprintf(" (GitLab %s)", v)
+	}
+	return ""
+}
+
+func (d *doctor) checkScopes(client *gitlabClient) {
+	token, err := tokenInfo(client)
+	if err != nil {
+		hint := ""
+		if _, ok := client.version(); !ok {
+			hint = fmt.Sprintf("Token introspection needs GitLab %s or newer.", featureTokenInfo.since)
+		}
+		d.warn("scopes", fmt.Sprintf("could not inspect token: %v", err), hint)
+		return
+	}
+
+	scopes := strings.Join(token.Scopes, ", ")
+	switch {
+	case token.hasScope("api"):
+		d.pass("scopes", scopes)
+	case token.hasScope("read_api"):
+		d.warn("scopes", scopes, "The read_api scope is enough to list members, but \"assign\" needs the api scope.")
+	default:
+		d.fail("scopes", fmt.Errorf("token lacks read_api: %s", scopes), fmt.Sprintf("Create a token with the api scope at\n%s", tokenCreationURL(client.project.Host)))
+	}
+}
+
+func (d *doctor) checkFork(client *gitlabClient) {
+	var project apiProject
+	if err := client.get(client.projectPath(""), nil, &project); err != nil {
+		d.fail("project access", err, fmt.Sprintf("Check that %s exists and that the token's user can see it.", client.project.Path))
+		return
+	}
+
+	switch upstream := project.ForkedFromProject; {
+	case upstream == nil:
+		d.pass("project access", project.PathWithNamespace)
+	case options.upstream:
+		d.pass("project access", fmt.Sprintf("%s, using the members of upstream %s", project.PathWithNamespace, upstream.PathWithNamespace))
+	default:
+		d.warn("project access", fmt.Sprintf("%s is a fork of %s", project.PathWithNamespace, upstream.PathWithNamespace),
+			fmt.Sprintf("Pass --upstream to list the members of %s, where the reviewers usually are.", upstream.PathWithNamespace))
+	}
+}
+
+func (d *doctor) checkCacheDir() {
+	path, err := getCachePath()
+	if err != nil {
+		d.skip("cache", "cache path depends on the git remote")
+		return
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		d.fail("cache", err, "Make sure the user cache directory is writable.")
+		return
+	}
+
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		d.fail("cache", err, fmt.Sprintf("Make sure %s is writable.", dir))
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	d.pass("cache", dir+" is writable")
+}
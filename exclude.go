@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// usernamePattern matches usernames for the exclude setting. It is written
+// as a plain username (with or without @), a glob like "*-deploy", or a
+// regular expression between slashes like "/^ext-/". Plain usernames and
+// globs match case-insensitively, like GitLab usernames do.
+type usernamePattern struct {
+	text string
+	re   *regexp.Regexp // nil unless the pattern is a regular expression
+}
+
+// parseUsernamePattern parses a usernamePattern.
+func parseUsernamePattern(s string) (usernamePattern, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return usernamePattern{}, fmt.Errorf("empty username")
+	}
+
+	if expr, ok := strings.CutPrefix(s, "/"); ok && len(expr) > 0 && strings.HasSuffix(expr, "/") {
+		re, err := regexp.Compile(strings.TrimSuffix(expr, "/"))
+		if err != nil {
+			return usernamePattern{}, fmt.Errorf("invalid regular expression %s: %w", s, err)
+		}
+		return usernamePattern{text: s, re: re}, nil
+	}
+
+	s = strings.ToLower(strings.TrimPrefix(s, "@"))
+	if _, err := path.Match(s, ""); err != nil {
+		return usernamePattern{}, fmt.Errorf("invalid pattern %q", s)
+	}
+	return usernamePattern{text: s}, nil
+}
+
+// parseUsernamePatterns parses a list of usernamePatterns.
+func parseUsernamePatterns(list []string) ([]usernamePattern, error) {
+	patterns := make([]usernamePattern, 0, len(list))
+	for _, s := range list {
+		p, err := parseUsernamePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func (p usernamePattern) match(username string) bool {
+	if p.re != nil {
+		return p.re.MatchString(username)
+	}
+	ok, _ := path.Match(p.text, strings.ToLower(username))
+	return ok
+}
+
+func (p usernamePattern) String() string {
+	return p.text
+}
+
+// excludePatterns returns the usernames and patterns never to list or
+// suggest: those of the exclude setting, followed by those in the
+// repository's gitlab-reviewer.exclude (see repoSetting), a comma-separated
+// list.
+func excludePatterns() ([]usernamePattern, error) {
+	patterns, err := parseUsernamePatterns(conf.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+
+	value, source := repoSetting("exclude")
+	for _, s := range strings.Split(value, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		p, err := parseUsernamePattern(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
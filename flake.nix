@@ -17,12 +17,25 @@
       );
 
       gitlab-reviewer =
-        { buildGoModule }:
-        buildGoModule {
+        { buildGoModule, installShellFiles }:
+        buildGoModule rec {
           pname = "gitlab-reviewer";
           version = "0.1.0";
           src = ./.;
           vendorHash = null;
+          ldflags = [
+            "-X main.version=${version}"
+            "-X main.commit=${self.rev or self.dirtyRev or "unknown"}"
+          ];
+          nativeBuildInputs = [ installShellFiles ];
+          postInstall = ''
+            $out/bin/gitlab-reviewer gen-man -dir man
+            installManPage man/*.1
+            installShellCompletion --cmd gitlab-reviewer \
+              --bash <($out/bin/gitlab-reviewer completion bash) \
+              --zsh <($out/bin/gitlab-reviewer completion zsh) \
+              --fish <($out/bin/gitlab-reviewer completion fish)
+          '';
         };
     in
     {
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitlabClient talks to the REST API of the GitLab instance hosting the
+// current project.
+type gitlabClient struct {
+	project *gitlabProject
+	id      int    // numeric ID of project, once known
+	host    string // API host, usually the same as project.Host
+	cred    *credential
+	credMu  sync.Mutex // guards cred, which reauth swaps while requests may be in flight
+	http    *http.Client
+	rate    rateLimit
+
+	versionOnce sync.Once
+	ver         *gitlabVersion // nil if the version is unknown
+}
+
+// apiUser represents the relevant fields of a GitLab user.
+type apiUser struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+// apiProject represents the relevant fields of a GitLab project.
+type apiProject struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	ForkedFromProject *struct {
+		ID                int    `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"forked_from_project"`
+}
+
+// apiError is a non-2xx response from the API.
+type apiError struct {
+	status int
+	body   string
+}
+
+func (e *apiError) Error() string {
+	// Truncate body to avoid dumping entire HTML error pages
+	preview := e.body
+	if len(preview) > 200 {
+		preview = preview[:200] + "..."
+	}
+	return fmt.Sprintf("API returned status %d: %s", e.status, preview)
+}
+
+// isStatus reports whether err is an API error with the given status.
+func isStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == status
+}
+
+// newGitLabClient detects the project from the git remote and reads the
+// access token.
+func newGitLabClient() (*gitlabClient, error) {
+	project, err := currentProject()
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := findToken(project.Host)
+	if err != nil && options.replay != "" {
+		// Recordings don't need a token
+		cred, err = &credential{source: "--replay"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !cred.oauth {
+		warnIfExpiring(cred.expiresAt)
+	}
+
+	client := newGitLabClientFor(project, cred)
+	if options.upstream {
+		if err := client.useUpstream(); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// useUpstream switches the client to the project the current one was
+// forked from, where the reviewers of a personal fork usually are.
+func (c *gitlabClient) useUpstream() error {
+	if c.project.Group {
+		return fmt.Errorf("--upstream needs a project, not a group")
+	}
+	var project apiProject
+	if err := c.get(c.projectPath(""), nil, &project); err != nil {
+		return fmt.Errorf("looking up the upstream of %s: %w", c.project.Path, err)
+	}
+	if project.ForkedFromProject == nil {
+		fmt.Fprintf(stderr, "warning: %s is not a fork, using it as is\n", c.project.Path)
+		return nil
+	}
+
+	c.project = &gitlabProject{Host: c.project.Host, Path: project.ForkedFromProject.PathWithNamespace, SSHPort: c.project.SSHPort}
+	c.id = project.ForkedFromProject.ID
+	return nil
+}
+
+func newGitLabClientFor(project *gitlabProject, cred *credential) *gitlabClient {
+	addSecret(cred.token)
+	return &gitlabClient{
+		project: project,
+		host:    conf.apiHost(project),
+		cred:    cred,
+		http:    httpClient(project.Host),
+	}
+}
+
+// projectPath returns the API path for the current project, with suffix
+// (e.g. "/members/all") appended. The project is addressed by its numeric
+// ID where possible, which keeps working after the project is renamed.
+func (c *gitlabClient) projectPath(suffix string) string {
+	if c.project.Group {
+		return "/groups/" + url.PathEscape(c.project.Path) + suffix
+	}
+	if id := c.projectID(); id != 0 {
+		return "/projects/" + strconv.Itoa(id) + suffix
+	}
+	// URL-encode the project path for the API call
+	return "/projects/" + url.PathEscape(c.project.Path) + suffix
+}
+
+// projectID returns the numeric ID of the project, looking it up once and
+// caching it across runs. It returns 0 if the lookup fails, in which case
+// the path is used and the actual request reports the problem.
+func (c *gitlabClient) projectID() int {
+	if c.id != 0 {
+		return c.id
+	}
+	if c.id = cachedProjectID(c.project); c.id != 0 {
+		return c.id
+	}
+
+	var project struct {
+		ID int `json:"id"`
+	}
+	if err := c.get("/projects/"+url.PathEscape(c.project.Path), nil, &project); err != nil {
+		return 0
+	}
+	c.id = project.ID
+	if err := cacheProjectID(c.project, c.id); err != nil {
+		fmt.Fprintf(stderr, "warning: could not cache project ID: %v\n", err)
+	}
+	return c.id
+}
+
+func (c *gitlabClient) get(path string, query url.Values, out any) error {
+	return c.do(http.MethodGet, path, query, nil, out)
+}
+
+func (c *gitlabClient) post(path string, body, out any) error {
+	return c.do(http.MethodPost, path, nil, body, out)
+}
+
+func (c *gitlabClient) put(path string, body, out any) error {
+	return c.do(http.MethodPut, path, nil, body, out)
+}
+
+// pageTag is the ETag of one page of a list, for conditional requests.
+type pageTag struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag"`
+}
+
+// getAll fetches every page of the list endpoint at path, following the
+// pagination headers, and returns the items of all pages.
+func getAll[T any](c *gitlabClient, path string, query url.Values) ([]T, error) {
+	items, _, err := getAllTagged[T](c, path, query)
+	return items, err
+}
+
+// getAllTagged is getAll, but also returns the URL and ETag of every page
+// fetched. If any page has no ETag, no tags are returned.
+//
+// Keyset pagination is asked for where GitLab supports it (see
+// keysetLists), as it recommends it for large collections: offset
+// pagination gets slow deep into a list and stops reporting totals past
+// 10,000 items. Should an instance reject it anyway, the list is fetched by
+// offset instead.
+func getAllTagged[T any](c *gitlabClient, path string, query url.Values) ([]T, []pageTag, error) {
+	params := url.Values{"per_page": {strconv.Itoa(perPage())}}
+	for k, v := range query {
+		params[k] = v
+	}
+
+	keyset := url.Values{"pagination": {"keyset"}, "order_by": {"id"}, "sort": {"asc"}}
+	for k, v := range params {
+		keyset[k] = v
+	}
+
+	// Instances too old to know keyset pagination are not asked for it
+	useKeyset := c.supports(featureKeysetPagination) && slices.Contains(keysetLists, path[strings.LastIndex(path, "/")+1:])
+	if !useKeyset {
+		keyset = params
+	}
+
+	all, tags, err := getPages[T](c, c.apiURL(path, keyset))
+	if useKeyset && (isStatus(err, http.StatusBadRequest) || isStatus(err, http.StatusMethodNotAllowed)) {
+		all, tags, err = getPages[T](c, c.apiURL(path, params))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, tag := range tags {
+		if tag.ETag == "" {
+			return all, nil, nil
+		}
+	}
+	return all, tags, nil
+}
+
+// keysetLists are the last path segments of the lists fetched here that
+// GitLab can page by keyset. Others, like members and protected branches,
+// reject it with 405 Method Not Allowed.
+var keysetLists = []string{"projects", "users"}
+
+// getPages fetches the page at apiURL and all pages after it. If the first
+// response says how many pages there are, which GitLab only does for offset
+// pagination, the rest are fetched concurrently.
+func getPages[T any](c *gitlabClient, apiURL string) ([]T, []pageTag, error) {
+	var first []T
+	header, err := c.request(http.MethodGet, apiURL, nil, &first)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := []pageTag{{URL: apiURL, ETag: header.Get("ETag")}}
+
+	if total, _ := strconv.Atoi(header.Get("X-Total-Pages")); total > 1 && header.Get("X-Next-Page") == "2" {
+		rest, restTags, err := getPagesConcurrently[T](c, apiURL, 2, total)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(first, rest...), append(tags, restTags...), nil
+	}
+
+	all := first
+	for apiURL = nextPageURL(apiURL, header); apiURL != ""; {
+		var page []T
+		header, err := c.request(http.MethodGet, apiURL, nil, &page)
+		if err != nil {
+			return nil, nil, err
+		}
+		all = append(all, page...)
+		tags = append(tags, pageTag{URL: apiURL, ETag: header.Get("ETag")})
+		apiURL = nextPageURL(apiURL, header)
+	}
+	return all, tags, nil
+}
+
+// getPagesConcurrently fetches the pages from through to of the offset
+// paginated list at apiURL in a batch, and returns their items in order.
+func getPagesConcurrently[T any](c *gitlabClient, apiURL string, from, to int) ([]T, []pageTag, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", apiURL, err)
+	}
+
+	pages := make([][]T, to-from+1)
+	tags := make([]pageTag, len(pages))
+	for i := range pages {
+		query := u.Query()
+		query.Set("page", strconv.Itoa(from+i))
+		pageURL := *u
+		pageURL.RawQuery = query.Encode()
+		tags[i].URL = pageURL.String()
+	}
+
+	err = batch(len(pages), func(i int) error {
+		header, err := c.request(http.MethodGet, tags[i].URL, nil, &pages[i])
+		if err != nil {
+			return err
+		}
+		tags[i].ETag = header.Get("ETag")
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var all []T
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+	return all, tags, nil
+}
+
+// notModified reports whether none of the pages fetched before has changed
+// since, according to conditional requests with their ETags.
+func (c *gitlabClient) notModified(tags []pageTag) bool {
+	if len(tags) == 0 {
+		return false
+	}
+	for _, tag := range tags {
+		resp, err := c.send(c.credential(), http.MethodGet, tag.URL, nil, http.Header{"If-None-Match": {tag.ETag}})
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			return false
+		}
+	}
+	return true
+}
+
+// nextPageURL returns the URL of the page after current, from the Link or
+// X-Next-Page header, or "" if current is the last page. Only the query
+// of the Link header is used: GitLab builds it from its own external URL,
+// which need not be the host (or even scheme) we send the token to.
+func nextPageURL(current string, header http.Header) string {
+	u, err := url.Parse(current)
+	if err != nil {
+		return ""
+	}
+
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, _ := strings.Cut(link, ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		u.RawQuery = next.RawQuery
+		return u.String()
+	}
+
+	if page := header.Get("X-Next-Page"); page != "" {
+		query := u.Query()
+		query.Set("page", page)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return ""
+}
+
+// apiURL returns the URL of the API endpoint path with query.
+func (c *gitlabClient) apiURL(path string, query url.Values) string {
+	apiURL := fmt.Sprintf("https://%s/api/v4%s", c.host, path)
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+	return apiURL
+}
+
+// do performs an API request. body, if non-nil, is sent as JSON and the
+// response is decoded into out when it is non-nil.
+func (c *gitlabClient) do(method, path string, query url.Values, body, out any) error {
+	_, err := c.request(method, c.apiURL(path, query), body, out)
+	return err
+}
+
+// request is do for a complete URL. It returns the response headers.
+// Rate-limited requests and transient failures are retried (see
+// retryDelay).
+func (c *gitlabClient) request(method, apiURL string, body, out any) (http.Header, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("encoding request: %w", err)
+		}
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if delay := c.rate.delay(); delay > 0 {
+			verbosef("rate limit nearly exhausted, waiting %s", delay.Round(time.Millisecond))
+			if err := sleep(delay); err != nil {
+				return nil, err
+			}
+		}
+
+		cred := c.credential()
+		resp, err := c.send(cred, method, apiURL, data, nil)
+		if err == nil {
+			c.rate.update(resp.Header)
+			if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+				err = decodeBody(resp.Body, out)
+				resp.Body.Close()
+				if err == nil {
+					return resp.Header, nil
+				}
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.Is(err, io.EOF) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					return nil, fmt.Errorf("parsing API response: %w", err)
+				}
+				// The connection broke off halfway through the response
+				resp, err = nil, fmt.Errorf("reading response: %w", err)
+			}
+		}
+		if delay, ok := retryDelay(method, resp, err, attempt, time.Since(start)); ok {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if err != nil {
+				verbosef("%v; retrying in %s", err, delay.Round(time.Millisecond))
+			} else {
+				verbosef("%s %s returned status %d; retrying in %s", method, apiURL, resp.StatusCode, delay.Round(time.Millisecond))
+			}
+			if err := sleep(delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Only error pages are read into memory, and only their start
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && c.reauth(cred) {
+			return c.request(method, apiURL, body, out)
+		}
+		return nil, &apiError{status: resp.StatusCode, body: string(respBody)}
+	}
+}
+
+// maxErrorBody is how much of the body of an error response is kept.
+const maxErrorBody = 64 << 10
+
+// decodeBody decodes the JSON response body into out, if non-nil, as it
+// streams in, so large pages are never held in memory twice.
+func decodeBody(body io.Reader, out any) error {
+	if out != nil {
+		if err := json.NewDecoder(body).Decode(out); err != nil {
+			return err
+		}
+	}
+	// Read the rest so the connection can be reused
+	io.Copy(io.Discard, body)
+	return nil
+}
+
+// credential returns the credential requests are sent with.
+func (c *gitlabClient) credential() *credential {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	return c.cred
+}
+
+func (c *gitlabClient) setCredential(cred *credential) {
+	c.credMu.Lock()
+	defer c.credMu.Unlock()
+	c.cred = cred
+}
+
+// send makes a single attempt at a request with cred and the extra
+// headers. The caller must close the response body.
+func (c *gitlabClient) send(cred *credential, method, apiURL string, data []byte, header http.Header) (*http.Response, error) {
+	var reqBody io.Reader
+	if data != nil {
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set(cred.header())
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// graphqlMembersQuery fetches a page of project members, including
+// inherited ones and those of invited groups, like /members/all. Group
+// members come with the group, to tell the two apart.
+const graphqlMembersQuery = `query($path: ID!, $search: String, $first: Int, $after: String) {
+  project(fullPath: $path) {
+    projectMembers(relations: [DIRECT, INHERITED, INVITED_GROUPS], search: $search, first: $first, after: $after) {
+      nodes {
+        user { id name username state avatarUrl webUrl bot }
+        accessLevel { integerValue }
+        expiresAt
+        __typename
+        ... on GroupMember { group { fullPath } }
+      }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+
+// graphqlError is an error reported in the errors of a GraphQL response.
+type graphqlError struct {
+	Message string `json:"message"`
+}
+
+// graphql runs query with vars against the GraphQL API and decodes the
+// data of the response into out.
+func (c *gitlabClient) graphql(query string, vars map[string]any, out any) error {
+	var resp struct {
+		Data   any            `json:"data"`
+		Errors []graphqlError `json:"errors"`
+	}
+	resp.Data = out
+
+	body := map[string]any{"query": query, "variables": vars}
+	if _, err := c.request(http.MethodPost, fmt.Sprintf("https://%s/api/graphql", c.host), body, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("GraphQL: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// absoluteURL resolves a URL GitLab gave relative to its own root, like
+// "/uploads/-/system/user/avatar/1/avatar.png".
+func absoluteURL(client *gitlabClient, ref string) string {
+	if !strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "//") {
+		return ref
+	}
+	return "https://" + client.host + ref
+}
+
+// fetchMembersGraphQL is fetchMembers over GraphQL, which needs a single
+// request per page of members. A member that is both a direct and an inherited
+// member is listed once, with their highest access level.
+func fetchMembersGraphQL(client *gitlabClient, query string) ([]apiMember, error) {
+	vars := map[string]any{"path": client.project.Path, "first": perPage()}
+	if query != "" {
+		vars["search"] = query
+	}
+
+	var members []apiMember
+	index := make(map[string]int)
+	for {
+		var data struct {
+			Project *struct {
+				ProjectMembers struct {
+					Nodes []struct {
+						User *struct {
+							ID       string `json:"id"` // e.g. "gid://gitlab/User/123"
+							Name     string `json:"name"`
+							Username string `json:"username"`
+							State    string `json:"state"`
+							// Uploaded avatars have a path relative to the instance
+							AvatarURL string `json:"avatarUrl"`
+							WebURL    string `json:"webUrl"`
+							Bot       bool   `json:"bot"`
+						} `json:"user"`
+						AccessLevel struct {
+							IntegerValue int `json:"integerValue"`
+						} `json:"accessLevel"`
+						ExpiresAt string `json:"expiresAt"`  // e.g. "2026-11-01T00:00:00Z"
+						Typename  string `json:"__typename"` // ProjectMember or GroupMember
+						Group     *struct {
+							FullPath string `json:"fullPath"`
+						} `json:"group"`
+					} `json:"nodes"`
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+				} `json:"projectMembers"`
+			} `json:"project"`
+		}
+		if err := client.graphql(graphqlMembersQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if data.Project == nil {
+			return nil, fmt.Errorf("project %s not found", client.project.Path)
+		}
+
+		for _, node := range data.Project.ProjectMembers.Nodes {
+			if node.User == nil {
+				continue
+			}
+			level := node.AccessLevel.IntegerValue
+			membership := membershipDirect
+			if node.Typename == "GroupMember" {
+				membership = membershipShared
+				if node.Group != nil && strings.HasPrefix(client.project.Path, node.Group.FullPath+"/") {
+					membership = membershipInherited
+				}
+			}
+			expiresAt, _, _ := strings.Cut(node.ExpiresAt, "T")
+			if i, ok := index[node.User.Username]; ok {
+				// Members stay members until their last membership ends
+				if members[i].ExpiresAt != "" && (expiresAt == "" || expiresAt > members[i].ExpiresAt) {
+					members[i].ExpiresAt = expiresAt
+				}
+				members[i].AccessLevel = max(members[i].AccessLevel, level)
+				members[i].Membership = closerMembership(members[i].Membership, membership)
+				continue
+			}
+			id, err := strconv.Atoi(path.Base(node.User.ID))
+			if err != nil {
+				return nil, fmt.Errorf("unexpected user ID %q", node.User.ID)
+			}
+			index[node.User.Username] = len(members)
+			members = append(members, apiMember{
+				ID:          id,
+				Name:        node.User.Name,
+				Username:    node.User.Username,
+				State:       node.User.State,
+				AccessLevel: level,
+				AvatarURL:   absoluteURL(client, node.User.AvatarURL),
+				WebURL:      node.User.WebURL,
+				Bot:         node.User.Bot,
+				Membership:  membership,
+				ExpiresAt:   expiresAt,
+			})
+		}
+
+		page := data.Project.ProjectMembers.PageInfo
+		if !page.HasNextPage {
+			return members, nil
+		}
+		vars["after"] = page.EndCursor
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// groupProjectMembers returns the members of every project in the group
+// given with --group, merged into one list. Each member lists the projects
+// they are a member of, and has the highest access level and closest
+// membership of those. Their membership expires when the last of them
+// does. See fetchAPIMembers for query and state.
+//
+// The list isn't cached: it takes a request per project, and is meant for
+// occasional reviews of a fleet of projects rather than everyday use.
+func groupProjectMembers(query, state string) ([]Member, error) {
+	if options.group == "" {
+		return nil, fmt.Errorf("-projects needs --group")
+	}
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := fetchGroupProjectMembers(client, query, state)
+	recordAPIResult(client.project.Host, err)
+	return members, err
+}
+
+func fetchGroupProjectMembers(client *gitlabClient, query, state string) ([]Member, error) {
+	type apiGroupProject struct {
+		ID                int    `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	}
+	projectParams := url.Values{"include_subgroups": {"true"}, "archived": {"false"}, "order_by": {"path"}, "sort": {"asc"}}
+	projects, err := getAll[apiGroupProject](client, client.projectPath("/projects"), projectParams)
+	if err != nil {
+		return nil, fmt.Errorf("listing the projects of %s: %w", client.project.Path, err)
+	}
+
+	params := url.Values{}
+	if query != "" {
+		params.Set("query", query)
+	}
+	if state == "active" {
+		params.Set("state", state)
+	}
+
+	lists := make([][]apiMember, len(projects))
+	err = batch(len(projects), func(i int) error {
+		path := "/projects/" + strconv.Itoa(projects[i].ID) + "/members"
+		list, err := getAll[apiMember](client, path+"/all", params)
+		if err != nil {
+			return fmt.Errorf("fetching the members of %s: %w", projects[i].PathWithNamespace, err)
+		}
+		// like in fetchAPIMembers, only /members lists the direct ones
+		direct, err := getAll[apiMember](client, path, params)
+		if err != nil {
+			return fmt.Errorf("fetching the direct members of %s: %w", projects[i].PathWithNamespace, err)
+		}
+		isDirect := make(map[int]bool)
+		for _, m := range direct {
+			isDirect[m.ID] = true
+		}
+		for j := range list {
+			list[j].Membership = membershipInherited
+			if isDirect[list[j].ID] {
+				list[j].Membership = membershipDirect
+			}
+		}
+		lists[i] = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	index := make(map[int]int)
+	for i, list := range lists {
+		for _, am := range list {
+			j, ok := index[am.ID]
+			if !ok {
+				j = len(members)
+				index[am.ID] = j
+				members = append(members, Member{
+					ID:         am.ID,
+					Name:       am.Name,
+					Username:   am.Username,
+					State:      am.State,
+					AvatarURL:  am.AvatarURL,
+					WebURL:     am.WebURL,
+					Bot:        am.Bot,
+					Membership: am.Membership,
+					ExpiresAt:  am.ExpiresAt,
+				})
+			}
+			members[j].AccessLevel = max(members[j].AccessLevel, am.AccessLevel)
+			members[j].Membership = closerMembership(members[j].Membership, am.Membership)
+			members[j].ExpiresAt = laterExpiry(members[j].ExpiresAt, am.ExpiresAt)
+			members[j].Projects = append(members[j].Projects, projects[i].PathWithNamespace)
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func newInitCommand() *command {
+	return &command{
+		name:    "init",
+		summary: "Interactively set up the token and config file",
+		help: `Walk through first-time setup: pick the GitLab host (detected from the
+git remote), create and verify a personal access token, and store it
+(in the credentials file, or the system keyring if token_store is set to
+keyring) along with a default config file. Steps that are already done are
+skipped.`,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if !isTerminal(os.Stdin) {
+				return fmt.Errorf("init is interactive; use \"auth login\" to pipe in a token")
+			}
+
+			return runInitWizard()
+		},
+	}
+}
+
+func runInitWizard() error {
+	host, err := promptLine("GitLab host", remoteHost())
+	if err != nil {
+		return err
+	}
+
+	if user := existingLogin(host); user != nil {
+		fmt.Fprintf(stderr, "Already logged in to %s as @%s.\n", host, user.Username)
+	} else if err := initToken(host); err != nil {
+		return err
+	}
+
+	return initConfig()
+}
+
+// existingLogin returns the user of the configured token if it works on
+// host.
+func existingLogin(host string) *apiUser {
+	cred, err := findToken(host)
+	if err != nil {
+		return nil
+	}
+	user, err := currentUser(newGitLabClientFor(&gitlabProject{Host: host}, cred))
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func initToken(host string) error {
+	tokenURL := tokenCreationURL(host)
+	fmt.Fprintf(stderr, "\nA personal access token with the api scope is needed. Create one at:\n  %s\n\n", tokenURL)
+
+	open, err := confirm("Open this page in your browser?", true)
+	if err != nil {
+		return err
+	}
+	if open {
+		if err := openBrowser(tokenURL); err != nil {
+			fmt.Fprintf(stderr, "warning: could not open browser: %v\n", err)
+		}
+	}
+
+	for {
+		token, err := promptSecret("Paste your token: ")
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return fmt.Errorf("no token given")
+		}
+
+		user, err := storeToken(host, token, conf.tokenStore())
+		if err == nil {
+			fmt.Fprintf(stderr, "Logged in to %s as @%s.\n", host, user.Username)
+			return nil
+		}
+
+		fmt.Fprintf(stderr, "%v\n", err)
+		retry, err := confirm("Try another token?", true)
+		if err != nil {
+			return err
+		}
+		if !retry {
+			return fmt.Errorf("no working token")
+		}
+	}
+}
+
+func initConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(stderr, "Using existing config %s.\n", path)
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := writeConfig(path, Config{}.withDefaults()); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "Wrote default config to %s.\n", path)
+	return nil
+}
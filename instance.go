@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitlabVersion is the major and minor version of a GitLab instance.
+type gitlabVersion struct {
+	major, minor int
+}
+
+// parseGitLabVersion parses a version as reported by GitLab, e.g.
+// "16.11.2-ee" or "17.0.0-pre".
+func parseGitLabVersion(s string) (gitlabVersion, error) {
+	majorStr, rest, _ := strings.Cut(s, ".")
+	minorStr, _, _ := strings.Cut(rest, ".")
+	major, err1 := strconv.Atoi(majorStr)
+	minor, err2 := strconv.Atoi(minorStr)
+	if err1 != nil || err2 != nil {
+		return gitlabVersion{}, fmt.Errorf("unrecognised GitLab version %q", s)
+	}
+	return gitlabVersion{major, minor}, nil
+}
+
+func (v gitlabVersion) before(other gitlabVersion) bool {
+	return v.major < other.major || v.major == other.major && v.minor < other.minor
+}
+
+func (v gitlabVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// feature is an API feature that older GitLab versions lack.
+type feature struct {
+	name  string
+	since gitlabVersion
+}
+
+var (
+	featureKeysetPagination = feature{"keyset pagination", gitlabVersion{13, 0}}
+	featureReviewers        = feature{"assigning reviewers", gitlabVersion{13, 8}}
+	featureTokenInfo        = feature{"token introspection", gitlabVersion{15, 5}}
+	featureTokenRotation    = feature{"token rotation", gitlabVersion{16, 10}}
+)
+
+// versionTTL is how long the version of an instance is cached.
+const versionTTL = 24 * time.Hour
+
+// version returns the version of the GitLab instance, as reported by
+// /metadata (or /version before GitLab 15.2) and cached for a day. The
+// second result is false if the version could not be determined, e.g.
+// because CI job tokens can't access either endpoint.
+func (c *gitlabClient) version() (gitlabVersion, bool) {
+	c.versionOnce.Do(func() {
+		s, err := c.versionString()
+		if err != nil {
+			verbosef("could not determine the GitLab version of %s: %v", c.host, err)
+			return
+		}
+		v, err := parseGitLabVersion(s)
+		if err != nil {
+			verbosef("%v", err)
+			return
+		}
+		c.ver = &v
+	})
+	if c.ver == nil {
+		return gitlabVersion{}, false
+	}
+	return *c.ver, true
+}
+
+func (c *gitlabClient) versionString() (string, error) {
+	versions, _ := readCacheMap[cachedVersion](versionsFile)
+	if cached, ok := versions[c.host]; ok && time.Since(cached.CheckedAt) < versionTTL {
+		return cached.Version, nil
+	}
+	if c.cred.job {
+		return "", fmt.Errorf("CI job tokens can't read it")
+	}
+
+	var meta struct {
+		Version string `json:"version"`
+	}
+	err := c.get("/metadata", nil, &meta)
+	if isStatus(err, http.StatusNotFound) {
+		err = c.get("/version", nil, &meta)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := cacheVersion(c.host, meta.Version); err != nil {
+		verbosef("could not cache the GitLab version: %v", err)
+	}
+	return meta.Version, nil
+}
+
+// supports reports whether the GitLab instance has f. When the version is
+// unknown, every feature is assumed to be there.
+func (c *gitlabClient) supports(f feature) bool {
+	v, ok := c.version()
+	return !ok || !v.before(f.since)
+}
+
+// require returns an error explaining that f is missing if the GitLab
+// instance is too old for it.
+func (c *gitlabClient) require(f feature) error {
+	if c.supports(f) {
+		return nil
+	}
+	v, _ := c.version()
+	return fmt.Errorf("%s needs GitLab %s or newer, but %s runs %s", f.name, f.since, c.host, v)
+}
@@ -0,0 +1,1013 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxverbeek/gitlab-reviewer/internal/gitlabtest"
+)
+
+// newFakeGitLab starts a fake GitLab instance with the project grp/proj and
+// points the tool at it, with an empty cache.
+func newFakeGitLab(t *testing.T) (*gitlabtest.Server, *gitlabtest.Project) {
+	t.Helper()
+
+	srv := gitlabtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", home+"/.cache")
+	t.Setenv("XDG_CONFIG_HOME", home+"/.config")
+	t.Setenv("GITLAB_TOKEN", srv.Token)
+
+	savedOptions, savedConf := options, conf
+	t.Cleanup(func() { options, conf = savedOptions, savedConf })
+	options.host = srv.Host()
+	options.project = "grp/proj"
+	conf = Config{}
+
+	httpClients[srv.Host()] = srv.Client()
+	t.Cleanup(func() { delete(httpClients, srv.Host()) })
+
+	return srv, srv.AddProject("grp/proj")
+}
+
+// newGitRepo makes options.dir a new git repository, and returns a function
+// that adds an empty commit by author to it, dated date unless that is "".
+func newGitRepo(t *testing.T) func(author, date string) {
+	t.Helper()
+
+	options.dir = t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := gitCommand(args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "--quiet")
+
+	return func(author, date string) {
+		t.Helper()
+		args := []string{"-c", "user.name=x", "-c", "user.email=x@example.com", "commit", "--quiet", "--allow-empty", "--author", author, "-m", "change"}
+		if date != "" {
+			t.Setenv("GIT_COMMITTER_DATE", date)
+			args = append(args, "--date", date)
+		}
+		git(args...)
+	}
+}
+
+func TestMembersFromFakeGitLab(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	for i := range 150 {
+		project.AddMember(srv.AddUser(fmt.Sprintf("user%03d", i), fmt.Sprintf("User %d", i)), accessLevels["developer"])
+	}
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 150 {
+		t.Fatalf("got %d members, want 150", len(members))
+	}
+	if members[0].Username != "user000" || members[149].Username != "user149" {
+		t.Errorf("members out of order: first %s, last %s", members[0].Username, members[149].Username)
+	}
+	if members[0].ID != 1 {
+		t.Errorf("first member has ID %d, want 1", members[0].ID)
+	}
+
+	// The second run is served from the cache
+	before := len(srv.Requests())
+	if _, err := getMembers(false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if after := len(srv.Requests()); after != before {
+		t.Errorf("cached run made %d requests", after-before)
+	}
+}
+
+func TestMemberSearch(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+	project.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+
+	members, err := getMembers(false, "ali", "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Username != "alice" {
+		t.Errorf("got %v, want only alice", members)
+	}
+}
+
+func TestBotMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	project.AddMember(srv.AddUser("project_1_bot_0a1b2c3d", "Deploy token"), accessLevels["maintainer"])
+	renovate := srv.AddUser("renovate", "Renovate Bot")
+	renovate.Bot = true
+	project.AddMember(renovate, accessLevels["developer"])
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filterMembers(members, memberFilter{}); len(got) != 1 || got[0].Username != "alice" {
+		t.Errorf("got %v, want only alice", got)
+	}
+	if got := filterMembers(members, memberFilter{IncludeBots: true}); len(got) != 3 {
+		t.Errorf("got %d members including bots, want 3", len(got))
+	}
+}
+
+func TestExcludeMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	for _, username := range []string{"alice", "Bob", "app-deploy", "ext-12"} {
+		project.AddMember(srv.AddUser(username, username), accessLevels["developer"])
+	}
+	conf.Exclude = []string{"@bob", "*-deploy", "/^ext-[0-9]+$/"}
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := excludePatterns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filterMembers(members, memberFilter{Exclude: exclude}); len(got) != 1 || got[0].Username != "alice" {
+		t.Errorf("got %v, want only alice", got)
+	}
+}
+
+func TestExcludeSelf(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	srv.CurrentUser = srv.AddUser("alice", "Alice Liddell")
+	project.AddMember(srv.CurrentUser, accessLevels["maintainer"])
+	project.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+
+	if self := lookUpSelf(); self != "alice" {
+		t.Fatalf("got self %q, want alice", self)
+	}
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filterMembers(members, memberFilter{Self: "alice"}); len(got) != 1 || got[0].Username != "bob" {
+		t.Errorf("got %v, want only bob", got)
+	}
+
+	// The user is only looked up once
+	before := len(srv.Requests())
+	if self := lookUpSelf(); self != "alice" {
+		t.Errorf("got self %q the second time, want alice", self)
+	}
+	if after := len(srv.Requests()); after != before {
+		t.Errorf("second lookup made %d requests", after-before)
+	}
+}
+
+func TestDirectMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+	project.AddGroupMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Membership != "direct" || members[1].Membership != "inherited" {
+		t.Fatalf("got %v, want alice direct and bob inherited", members)
+	}
+	if got := filterMembers(members, memberFilter{Direct: true}); len(got) != 1 || got[0].Username != "alice" {
+		t.Errorf("got %v, want only alice", got)
+	}
+}
+
+func TestSharedGroupMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	project.AddMember(alice, accessLevels["developer"])
+	reviewers := srv.AddGroup("other/reviewers")
+	reviewers.AddMember(alice, accessLevels["owner"])
+	reviewers.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["owner"])
+	project.ShareWith(reviewers, accessLevels["developer"])
+
+	options.sharedGroups = true
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Member{
+		{Username: "alice", AccessLevel: accessLevels["developer"], Membership: "direct"},
+		{Username: "bob", AccessLevel: accessLevels["developer"], Membership: "shared"},
+	}
+	if len(members) != len(want) {
+		t.Fatalf("got %v, want alice and bob", members)
+	}
+	for i, m := range members {
+		if m.Username != want[i].Username || m.AccessLevel != want[i].AccessLevel || m.Membership != want[i].Membership {
+			t.Errorf("got %s (%d, %s), want %s (%d, %s)", m.Username, m.AccessLevel, m.Membership, want[i].Username, want[i].AccessLevel, want[i].Membership)
+		}
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	team := srv.AddGroup("grp/team")
+	team.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+	team.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+
+	options.project = ""
+	options.group = "grp/team"
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Username != "alice" || members[1].Username != "bob" {
+		t.Errorf("got %v, want alice and bob", members)
+	}
+
+	path, err := getCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "grp-team-group.json" {
+		t.Errorf("group members cached in %s", path)
+	}
+}
+
+func TestGroupProjectMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	srv.AddGroup("grp")
+	alice := srv.AddUser("alice", "Alice Liddell")
+	project.AddMember(alice, accessLevels["developer"])
+	other := srv.AddProject("grp/sub/other")
+	other.AddMember(alice, accessLevels["maintainer"])
+	other.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+
+	options.project = ""
+	options.group = "grp"
+	members, err := groupProjectMembers("", "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("got %v, want alice and bob", members)
+	}
+	if alice := members[0]; !slices.Equal(alice.Projects, []string{"grp/proj", "grp/sub/other"}) || alice.AccessLevel != accessLevels["maintainer"] {
+		t.Errorf("got alice in %v as %d, want in both projects as maintainer", alice.Projects, alice.AccessLevel)
+	}
+	if bob := members[1]; !slices.Equal(bob.Projects, []string{"grp/sub/other"}) {
+		t.Errorf("got bob in %v, want only in grp/sub/other", bob.Projects)
+	}
+}
+
+func TestGroupProjectMembersFilters(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	srv.AddGroup("grp")
+	alice := srv.AddUser("alice", "Alice Liddell")
+	project.AddMember(alice, accessLevels["developer"])
+	project.AddGroupMember(srv.AddUser("carol", "Carol Danvers"), accessLevels["developer"])
+	other := srv.AddProject("grp/other")
+	other.AddMember(alice, accessLevels["developer"])
+	other.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+	soon := time.Now().AddDate(0, 0, 3).Format(time.DateOnly)
+	other.Members[0].ExpiresAt = soon
+	other.Members[1].ExpiresAt = soon
+
+	options.project = ""
+	options.group = "grp"
+	members, err := groupProjectMembers("", "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		filter memberFilter
+		want   []string
+	}{
+		{memberFilter{Direct: true}, []string{"alice", "bob"}},
+		{memberFilter{ExpiringWithin: 14 * 24 * time.Hour}, []string{"alice", "carol"}},
+	} {
+		var got []string
+		for _, m := range filterMembers(members, tt.filter) {
+			got = append(got, m.Username)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("filter %+v: got %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCanMergeMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+	project.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+	carol := srv.AddUser("carol", "Carol Danvers")
+	project.AddMember(carol, accessLevels["developer"])
+	project.Protect("main", accessLevels["maintainer"]).MergeUsers = []*gitlabtest.User{carol}
+	project.Protect("release/*", 0)
+
+	options.dir = t.TempDir() // not on a branch, so the default branch is used
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for base, want := range map[string][]string{
+		"":                {"alice", "carol"},
+		"feature":         {"alice", "bob", "carol"},
+		"release/1.0":     nil,
+		"refs/heads/main": {"alice", "carol"},
+	} {
+		access, err := lookUpMergeAccess(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range filterMembers(members, memberFilter{Merge: access}) {
+			got = append(got, m.Username)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("base %q: got %q, want %q", base, got, want)
+		}
+	}
+}
+
+func TestSkipExpiringMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	project.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+	project.AddMember(srv.AddUser("carol", "Carol Danvers"), accessLevels["developer"])
+	project.Members[1].ExpiresAt = time.Now().AddDate(0, 0, 3).Format(time.DateOnly)
+	project.Members[2].ExpiresAt = time.Now().AddDate(0, 2, 0).Format(time.DateOnly)
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[1].ExpiresAt == "" {
+		t.Errorf("bob's membership has no expiry")
+	}
+	got := filterMembers(members, memberFilter{ExpiringWithin: 14 * 24 * time.Hour})
+	if len(got) != 2 || got[0].Username != "alice" || got[1].Username != "carol" {
+		t.Errorf("got %v, want alice and carol", got)
+	}
+}
+
+func TestSkipAwayMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	bob := srv.AddUser("bob", "Bob Builder")
+	carol := srv.AddUser("carol", "Carol Danvers")
+	for _, u := range []*gitlabtest.User{alice, bob, carol} {
+		project.AddMember(u, accessLevels["developer"])
+	}
+	bob.Status = gitlabtest.Status{Availability: "busy"}
+	carol.Status = gitlabtest.Status{Emoji: "palm_tree", Message: "Back on Monday"}
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := newMemberLookups(nil, true, 0)
+	var kept []string
+	for _, m := range lookups.apply(members) {
+		if lookups.keep(m) {
+			kept = append(kept, m.Username)
+		}
+	}
+	if !slices.Equal(kept, []string{"alice"}) {
+		t.Errorf("kept %v, want only alice", kept)
+	}
+}
+
+func TestSkipInactiveMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	bob := srv.AddUser("bob", "Bob Builder")
+	carol := srv.AddUser("carol", "Carol Danvers")
+	for _, u := range []*gitlabtest.User{alice, bob, carol} {
+		project.AddMember(u, accessLevels["developer"])
+	}
+	alice.LastActivityOn = time.Now().Format(time.DateOnly)
+	bob.LastActivityOn = time.Now().AddDate(0, -6, 0).Format(time.DateOnly)
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := newMemberLookups(nil, false, 90*24*time.Hour)
+	var kept []string
+	for _, m := range lookups.apply(members) {
+		if lookups.keep(m) {
+			kept = append(kept, m.Username)
+		}
+	}
+	// carol's activity is unknown, so she stays
+	if !slices.Equal(kept, []string{"alice", "carol"}) {
+		t.Errorf("kept %v, want alice and carol", kept)
+	}
+}
+
+func TestMemberProfiles(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	alice.Location = time.FixedZone("IST", 5*3600+1800)
+	bob := srv.AddUser("bob", "Bob Builder")
+	bob.Location = time.FixedZone("PST", -8*3600)
+	project.AddMember(alice, accessLevels["developer"])
+	project.AddMember(bob, accessLevels["developer"])
+	carol := srv.AddUser("carol", "Carol Danvers")
+	carol.PublicEmail = "carol@example.com"
+	project.AddMember(carol, accessLevels["developer"])
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var timezones, emails []string
+	for _, m := range newMemberLookups([]string{"timezone", "email"}, false, 0).apply(members) {
+		timezones = append(timezones, m.Timezone)
+		emails = append(emails, m.Email)
+	}
+	if want := []string{"UTC+05:30", "UTC-08:00", ""}; !slices.Equal(timezones, want) {
+		t.Errorf("got timezones %q, want %q", timezones, want)
+	}
+	if want := []string{"", "", "carol@example.com"}; !slices.Equal(emails, want) {
+		t.Errorf("got emails %q, want %q", emails, want)
+	}
+}
+
+func TestProfileKeepsCommitEmail(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	bob := srv.AddUser("bob", "Bob Builder")
+	bob.PublicEmail = "bob@example.com"
+
+	members, err := withProfiles([]Member{
+		{ID: alice.ID, Username: "alice", Email: "alice@work.example"},
+		{ID: bob.ID, Username: "bob", Email: "bob@work.example"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[0].Email != "alice@work.example" || members[1].Email != "bob@example.com" {
+		t.Errorf("got emails %q and %q, want alice's commit email and bob's public one", members[0].Email, members[1].Email)
+	}
+}
+
+func TestMemberIdentities(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	alice.Identities = []gitlabtest.Identity{{Provider: "ldapmain", ExternUID: "uid=alice,ou=people,dc=example,dc=com"}}
+	project.AddMember(alice, accessLevels["developer"])
+
+	lookups := newMemberLookups([]string{"identities"}, false, 0)
+	identities := func() string {
+		members, err := getMembers(false, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return memberRow(lookups.apply(members)[0], []string{"identities"})
+	}
+
+	if got := identities(); got != "" {
+		t.Errorf("got identities %q without admin rights", got)
+	}
+	srv.CurrentUser = srv.AddUser("root", "Administrator")
+	srv.CurrentUser.Admin = true
+	if got, want := identities(), "ldapmain:uid=alice,ou=people,dc=example,dc=com"; got != want {
+		t.Errorf("got identities %q, want %q", got, want)
+	}
+}
+
+func TestContributors(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	bob := srv.AddUser("bob", "Bob Builder")
+	bob.PublicEmail = "bbuilder@corp.example"
+	project.AddMember(bob, accessLevels["developer"])
+	srv.AddUser("dave", "Dave Lister") // not a member
+	srv.AddUser("erin", "Erin")        // not a member, with a private email
+
+	commit := newGitRepo(t)
+	// Bob's commit only matches him once his email is looked up
+	for _, author := range []string{"Alice Liddell <alice@example.com>", "Dave <dave@example.com>", "Alice <alice@example.com>", "Erin <erin@example.org>", "B. Builder <bbuilder@corp.example>"} {
+		commit(author, "")
+	}
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err = withContributors(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range members {
+		got = append(got, memberRow(m, []string{"name", "username", "membership", "commits"}))
+	}
+	want := []string{
+		"Alice Liddell\talice\tdirect\t2",
+		"Bob Builder\tbob\tdirect\t1",
+		"Dave Lister\tdave\tnone\t1",
+		"Erin\terin\tnone\t1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGitLogFallbackDoesNotSearchAuthors(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	srv.AddUser("alice", "Alice Liddell")
+	srv.AddUser("dave", "Dave Lister")
+	options.project = "grp/missing" // the API fails
+
+	commit := newGitRepo(t)
+	for _, author := range []string{"Alice Liddell <alice@example.com>", "Dave Lister <dave@example.com>"} {
+		commit(author, "")
+	}
+	err := updateCacheMap(authorsFile, func(authors map[string]cachedAuthor) {
+		authors[srv.Host()+" alice@example.com"] = cachedAuthor{ID: 1, Username: "alice", Name: "Alice Liddell", CheckedAt: time.Now()}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := getMembers(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range members {
+		got = append(got, memberRow(m, []string{"name", "username"}))
+	}
+	if want := []string{"Alice Liddell\talice", "Dave Lister\t"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/users") {
+			t.Errorf("searched for authors after the API failed: %s", r)
+		}
+	}
+}
+
+func TestContributorsSince(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+
+	commit := newGitRepo(t)
+	commit("Dave <dave@example.com>", time.Now().AddDate(-3, 0, 0).Format(time.RFC3339))
+	commit("Alice Liddell <alice@example.com>", time.Now().Format(time.RFC3339))
+
+	for since, want := range map[string][]string{
+		"":    {"alice\t1"},
+		"all": {"alice\t1", "\t1"},
+		"1d":  {"alice\t1"},
+	} {
+		options.since = since
+		members, err := withContributors(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range members {
+			got = append(got, memberRow(m, []string{"username", "commits"}))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("--since %q: got %q, want %q", since, got, want)
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	if _, err := getMembers(false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	path, err := getCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.CacheTTL = duration(7 * 24 * time.Hour)
+	t.Setenv("GITLAB_REVIEWER_CACHE_TTL", "30m")
+	for _, ttl := range []duration{0, duration(2 * time.Hour)} {
+		options.ttl = ttl
+		before := len(srv.Requests())
+		if _, err := getMembers(false, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		// The hour-old cache is stale for the 30m of the environment,
+		// which beats the setting, but not for the 2h of --ttl
+		if refreshed := len(srv.Requests()) > before; refreshed != (ttl == 0) {
+			t.Errorf("--ttl %v: refreshed = %v", ttl, refreshed)
+		}
+		if err := os.Chtimes(path, hourAgo, hourAgo); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	envDir, flagDir := t.TempDir(), t.TempDir()
+	t.Setenv("GITLAB_REVIEWER_CACHE_DIR", envDir)
+
+	// The flag wins over the environment
+	for _, dir := range []string{envDir, flagDir} {
+		if dir == flagDir {
+			options.cacheDir = flagDir
+		}
+		if _, err := getMembers(false, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "grp-proj.json")); err != nil {
+			t.Errorf("members not cached in %s: %v", dir, err)
+		}
+	}
+}
+
+func TestClearSharedCacheDir(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	options.cacheDir = t.TempDir()
+	others := []string{"notes.txt", "settings.json", "sub/data.json"}
+	for _, name := range others {
+		path := filepath.Join(options.cacheDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`{"theme": "dark"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := getMembers(false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := clearCacheDir(options.cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	err := filepath.WalkDir(options.cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(options.cacheDir, path)
+			left = append(left, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(left, others) {
+		t.Errorf("left %q, want only %q", left, others)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	project.AddMember(srv.AddUser("bob", "Bob Builder"), accessLevels["developer"])
+	if _, err := getMembers(false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cache maps are skipped by name, whatever they contain
+	if err := os.WriteFile(filepath.Join(dir, versionsFile), []byte(`[{"username": "alice"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := cacheStatuses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The project ID is cached too, but isn't a member list
+	if len(statuses) != 1 {
+		t.Fatalf("got %d member lists, want 1: %v", len(statuses), statuses)
+	}
+	s := statuses[0]
+	if filepath.Base(s.Path) != "grp-proj.json" || s.Members != 2 || s.Host != srv.Host() || s.Stale {
+		t.Errorf("got %+v, want fresh grp-proj.json with 2 members from %s", s, srv.Host())
+	}
+}
+
+func TestCacheWritesLeaveNoTempFiles(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	for range 2 {
+		if _, err := getMembers(true, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("left %s behind", e.Name())
+		}
+	}
+	members, err := readCacheIgnoreTTL(filepath.Join(dir, "grp-proj.json"))
+	if err != nil || len(members) != 1 {
+		t.Errorf("got %v, %v from the cache, want alice", members, err)
+	}
+}
+
+func TestConcurrentRefreshes(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+	path, err := getCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshes wait while another process holds the lock
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if members, err := refreshFromGitLab(path); err != nil || len(members) != 1 {
+				t.Errorf("got %v, %v, want alice", members, err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// which then writes the cache, so they don't need to fetch the members
+	if err := writeCache(path, []Member{{Username: "alice", Membership: membershipDirect}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	wg.Wait()
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/members") {
+			t.Errorf("fetched the members: %s", r)
+		}
+	}
+}
+
+func TestSuggestCountFlags(t *testing.T) {
+	for _, args := range [][]string{{"-n", "-1"}, {"-n", "0"}, {"-limit", "-1"}} {
+		cmd := newSuggestCommand()
+		if err := cmd.flags.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		err := cmd.run(nil)
+		if want := args[0] + " must be at least 1"; err == nil || err.Error() != want {
+			t.Errorf("%q: got %v, want %s", args, err, want)
+		}
+	}
+}
+
+func TestGitLabPATIsLastResort(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	t.Setenv("GITLAB_TOKEN", "")
+	home := os.Getenv("HOME")
+	if err := os.WriteFile(home+"/.gitlab_pat", []byte("glpat-stale\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	netrc := home + "/.netrc"
+	if err := os.WriteFile(netrc, []byte("machine "+srv.Host()+" password "+srv.Token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := findToken(srv.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.source != "~/.netrc" {
+		t.Errorf("got the token from %s, want ~/.netrc", cred.source)
+	}
+
+	if err := os.Remove(netrc); err != nil {
+		t.Fatal(err)
+	}
+	cred, err = findToken(srv.Host())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.source != "~/.gitlab_pat" {
+		t.Errorf("got the token from %s without a host token, want ~/.gitlab_pat", cred.source)
+	}
+}
+
+func TestLogoutKeepsSharedPAT(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	t.Setenv("GITLAB_TOKEN", "")
+	pat := os.Getenv("HOME") + "/.gitlab_pat"
+	if err := os.WriteFile(pat, []byte("glpat-other-host\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCredentialsToken(srv.Host(), srv.Token, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newAuthLogoutCommand().run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pat); err != nil {
+		t.Errorf("logging out of a host with its own token removed ~/.gitlab_pat: %v", err)
+	}
+	// Now the host is using ~/.gitlab_pat
+	if err := newAuthLogoutCommand().run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pat); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("~/.gitlab_pat is still there after logging out of the host using it: %v", err)
+	}
+}
+
+func TestConcurrentRequestsShareNewToken(t *testing.T) {
+	srv, _ := newFakeGitLab(t)
+	for i := range 10 {
+		srv.AddUser(fmt.Sprintf("user%02d", i), fmt.Sprintf("User %d", i))
+	}
+
+	// Another request already asked for a new token, after the old one was
+	// revoked; the others pick it up instead of prompting again
+	client, err := newGitLabClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Token = "glpat-new"
+	reauthState.cred = &credential{token: "glpat-new", source: "re-entered token"}
+	t.Cleanup(func() { reauthState.cred = nil })
+
+	err = batch(10, func(i int) error {
+		_, err := lookupUser(client, fmt.Sprintf("user%02d", i))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.credential().token != "glpat-new" {
+		t.Errorf("client still uses %s", client.credential().source)
+	}
+}
+
+func TestRefreshUnchangedMembers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+
+	path, err := getCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refreshFromGitLab(path); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(srv.Requests())
+	members, err := refreshFromGitLab(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 {
+		t.Errorf("got %d members, want 1", len(members))
+	}
+	// Only the conditional requests for the single page of all and of the
+	// direct members
+	if requests := srv.Requests()[before:]; len(requests) != 2 {
+		t.Errorf("unchanged refresh made requests %v", requests)
+	}
+}
+
+func TestMembersPagedByOffset(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["developer"])
+
+	if _, err := fetchFromGitLab("", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, "/members") && strings.Contains(r, "pagination=keyset") {
+			t.Errorf("asked for keyset pagination of members: %s", r)
+		}
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	project.AddMember(srv.AddUser("alice", "Alice Liddell"), accessLevels["maintainer"])
+	srv.Fail(http.StatusServiceUnavailable)
+
+	members, err := fetchFromGitLab("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 {
+		t.Errorf("got %d members, want 1", len(members))
+	}
+}
+
+func TestRetryBudgetFollowsTimeout(t *testing.T) {
+	newFakeGitLab(t)
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"2"}}}
+
+	if _, ok := retryDelay(http.MethodGet, resp, nil, 1, 0); !ok {
+		t.Errorf("not retried after 2s with the default timeout")
+	}
+	options.timeout = 500 * time.Millisecond
+	if _, ok := retryDelay(http.MethodGet, resp, nil, 1, 0); ok {
+		t.Errorf("retried after 2s with a timeout of %v", options.timeout)
+	}
+}
+
+func TestReadUsernamesByField(t *testing.T) {
+	input := "alice\talice@example.com\nbob\t\n\ncarol\n"
+	got, err := readUsernames(strings.NewReader(input), []string{"username", "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "bob", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := readUsernames(strings.NewReader(input), []string{"name", "email"}); err == nil {
+		t.Errorf("read usernames from lines without a username column")
+	}
+}
+
+func TestAssignReviewers(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	alice := srv.AddUser("alice", "Alice Liddell")
+	bob := srv.AddUser("bob", "Bob Builder")
+	carol := srv.AddUser("carol", "Carol Danvers")
+	mr := project.AddMergeRequest("feature")
+	mr.Reviewers = []*gitlabtest.User{alice}
+
+	client, err := newGitLabClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := getMergeRequest(client, mr.IID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := assignReviewers(client, found, []string{"bob", "@carol"}, true); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range mr.Reviewers {
+		got = append(got, u.Username)
+	}
+	if want := []string{alice.Username, bob.Username, carol.Username}; !slices.Equal(got, want) {
+		t.Errorf("reviewers are %v, want %v", got, want)
+	}
+
+	_, err = assignReviewers(client, found, []string{"mallory"}, false)
+	if err == nil || !strings.Contains(err.Error(), "unknown user mallory") {
+		t.Errorf("assigning an unknown user: got error %v", err)
+	}
+}
+
+func TestAssignReviewersOldGitLab(t *testing.T) {
+	srv, project := newFakeGitLab(t)
+	srv.AddUser("alice", "Alice Liddell")
+	srv.Version = "13.4.2-ee"
+	project.AddMergeRequest("feature")
+
+	client, err := newGitLabClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = assignReviewers(client, &apiMergeRequest{IID: 1}, []string{"alice"}, false)
+	if err == nil || !strings.Contains(err.Error(), "needs GitLab 13.8") {
+		t.Errorf("got error %v, want one about the GitLab version", err)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The macOS Keychain is accessed through the security command line tool.
+
+func keyringGet(host string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", host, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("no token for %s in the keychain: %w", host, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keyringSet runs the command in security's interactive mode, read from
+// stdin, so that the token doesn't show up in the process list like
+// arguments do.
+func keyringSet(host, token string) error {
+	if strings.ContainsAny(host+token, "\"\\\n") {
+		return fmt.Errorf("cannot store a token for %q in the keychain", host)
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -l %q -w %q\n",
+		keyringService, host, "gitlab-reviewer token for "+host, token))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// security -i doesn't fail when a command does, but reports it
+	if err := cmd.Run(); err != nil || stderr.Len() > 0 {
+		return fmt.Errorf("security add-generic-password: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func keyringDelete(host string) error {
+	if err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", host).Run(); err != nil {
+		return fmt.Errorf("security delete-generic-password: %w", err)
+	}
+	return nil
+}
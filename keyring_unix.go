@@ -0,0 +1,43 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service (GNOME Keyring, KWallet, KeePassXC, ...) is accessed
+// through secret-tool from libsecret.
+
+func keyringGet(host string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "host", host).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup: %w", err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("no token for %s in the keyring", host)
+	}
+	return token, nil
+}
+
+func keyringSet(host, token string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "gitlab-reviewer token for "+host, "service", keyringService, "host", host)
+	cmd.Stdin = strings.NewReader(token)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func keyringDelete(host string) error {
+	if err := exec.Command("secret-tool", "clear", "service", keyringService, "host", host).Run(); err != nil {
+		return fmt.Errorf("secret-tool clear: %w", err)
+	}
+	return nil
+}
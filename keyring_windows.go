@@ -0,0 +1,105 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// The Windows Credential Manager is accessed through the Cred* functions of
+// advapi32.dll. Tokens are stored as generic credentials named
+// "gitlab-reviewer:<host>".
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// winCredential mirrors the CREDENTIALW structure.
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credTarget(host string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(keyringService + ":" + host)
+}
+
+func keyringGet(host string) (string, error) {
+	target, err := credTarget(host)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *winCredential
+	r, _, callErr := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		return "", fmt.Errorf("no token for %s in the credential manager: %w", host, callErr)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func keyringSet(host, token string) error {
+	target, err := credTarget(host)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(host)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(token)
+	if len(blob) == 0 {
+		return fmt.Errorf("empty token")
+	}
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	r, _, callErr := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return fmt.Errorf("writing to the credential manager: %w", callErr)
+	}
+	return nil
+}
+
+func keyringDelete(host string) error {
+	target, err := credTarget(host)
+	if err != nil {
+		return err
+	}
+
+	r, _, callErr := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if r == 0 {
+		return fmt.Errorf("deleting from the credential manager: %w", callErr)
+	}
+	return nil
+}
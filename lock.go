@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockTimeout is how long to wait for another process to release a cache
+// file, e.g. while it refreshes the members. It is longer than a refresh
+// normally takes, including retries.
+const lockTimeout = time.Minute
+
+// lockFile takes an advisory lock on path, by way of path+".lock", so that
+// processes running at the same time (say, a prompt segment and an editor)
+// update the file one after the other. It waits for the process holding
+// the lock, and returns a function that releases it.
+func lockFile(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	waited := false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+
+		if !waited {
+			verbosef("waiting for another gitlab-reviewer to release %s", f.Name())
+			waited = true
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another gitlab-reviewer has held %s for over %s", f.Name(), lockTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// memberLookups are the member details that take an API request per member
+// to look up, and the filters based on them, as asked for with flags.
+type memberLookups struct {
+	status       bool          // look up statuses
+	profile      bool          // look up profiles, for the email address, timezone and identities
+	activity     bool          // look up the last activity, from the profile or events
+	skipBusy     bool          // leave out members whose status says they are away
+	skipInactive time.Duration // leave out members inactive for longer, if non-zero
+}
+
+// newMemberLookups returns the lookups needed to print fields and apply the
+// given filters.
+func newMemberLookups(fields []string, skipBusy bool, skipInactive time.Duration) memberLookups {
+	return memberLookups{
+		status:       skipBusy || slices.Contains(fields, "status") || slices.Contains(fields, "busy"),
+		profile:      slices.ContainsFunc(fields, func(f string) bool { return f == "email" || f == "identities" || f == "timezone" }),
+		activity:     skipInactive > 0 || slices.Contains(fields, "last_activity"),
+		skipBusy:     skipBusy,
+		skipInactive: skipInactive,
+	}
+}
+
+// apply looks up the details of members. The result has the same members
+// in the same order; see keep for filtering them.
+func (l memberLookups) apply(members []Member) []Member {
+	if l.status {
+		members = lookUpStatuses(members)
+	}
+	if l.profile || l.activity {
+		members = lookUpProfiles(members, l.activity)
+	}
+	return members
+}
+
+// keep reports whether m passes the filters of l, once its details are
+// looked up.
+func (l memberLookups) keep(m Member) bool {
+	if l.skipBusy && m.Status.away() {
+		return false
+	}
+	if l.skipInactive > 0 && m.inactiveFor(l.skipInactive) {
+		return false
+	}
+	return true
+}
@@ -1,324 +1,95 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
 	"strings"
-	"time"
 )
 
-const cacheTTL = 24 * time.Hour
-
-type Member struct {
-	Name     string `json:"name"`
-	Username string `json:"username"`
-}
-
-// apiMember represents the relevant fields from the GitLab API response.
-type apiMember struct {
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	State    string `json:"state"`
-}
-
-// gitlabProject holds the parsed host and project path from a git remote URL.
-type gitlabProject struct {
-	Host string // e.g. "gitlab.com"
-	Path string // e.g. "researchable/myproject"
-}
-
 func main() {
-	refresh := flag.Bool("refresh", false, "Force refresh the cache from GitLab API")
-	jsonOut := flag.Bool("json", false, "Output as JSON instead of TSV")
-	flag.Parse()
-
-	members, err := getMembers(*refresh)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-
-	if *jsonOut {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		if err := enc.Encode(members); err != nil {
-			fmt.Fprintf(os.Stderr, "error encoding json: %v\n", err)
-			os.Exit(1)
-		}
-	} else {
-		for _, m := range members {
-			fmt.Printf("%s\t%s\n", m.Name, m.Username)
-		}
-	}
-}
-
-func getMembers(forceRefresh bool) ([]Member, error) {
-	cachePath, cachePathErr := getCachePath()
-
-	// Try to use cache if not forcing refresh and cache path is available
-	if cachePathErr == nil && !forceRefresh {
-		members, err := readCache(cachePath)
-		if err == nil {
-			return members, nil
-		}
-		// Cache miss or stale, continue to refresh
-	}
-
-	// Try GitLab API directly
-	members, err := fetchFromGitLab()
-	if err == nil {
-		// Write cache (best effort)
-		if cachePathErr == nil {
-			if writeErr := writeCache(cachePath, members); writeErr != nil {
-				fmt.Fprintf(os.Stderr, "warning: could not write cache: %v\n", writeErr)
-			}
-		}
-		return members, nil
-	}
-
-	fmt.Fprintf(os.Stderr, "warning: GitLab API failed: %v\n", err)
-
-	// Try stale cache
-	if cachePathErr == nil {
-		members, staleErr := readCacheIgnoreTTL(cachePath)
-		if staleErr == nil {
-			fmt.Fprintf(os.Stderr, "warning: using stale cache\n")
-			return members, nil
+	cancelOnSignal()
+	if err := run(os.Args[1:]); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(stderr, "interrupted")
+			os.Exit(exitInterrupted)
 		}
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
-
-	// Last resort: git log
-	fmt.Fprintf(os.Stderr, "warning: falling back to git log contributors (no GitLab usernames available)\n")
-	members, gitLogErr := fetchFromGitLog()
-	if gitLogErr != nil {
-		fmt.Fprintf(os.Stderr, "warning: git log failed: %v\n", gitLogErr)
-		return []Member{}, nil
-	}
-
-	return members, nil
 }
 
-func getRemoteURL() (string, error) {
-	out, err := exec.Command("git", "remote", "get-url", "origin").Output()
-	if err != nil {
-		return "", fmt.Errorf("not a git repo or no origin remote: %w", err)
-	}
-	return strings.TrimSpace(string(out)), nil
+func newRootCommand() *command {
+	root := &command{
+		name: "gitlab-reviewer",
+		help: `List GitLab project members for the current repository and help pick
+merge request reviewers. Without a command, "members" is run.
+
+Use --version to print version and build information.`,
+		commands: []*command{
+			newMembersCommand(),
+			newSuggestCommand(),
+			newAssignCommand(),
+			newCacheCommand(),
+			newDoctorCommand(),
+			newWhoamiCommand(),
+			newInitCommand(),
+			newAuthCommand(),
+			newConfigCommand(),
+			newVersionCommand(),
+			newCompletionCommand(),
+		},
+	}
+	root.commands = append(root.commands, newHelpCommand(root), newGenManCommand(root), newCompleteCommand(root))
+	addGlobalFlagsTree(root)
+	return root
 }
 
-func getCachePath() (string, error) {
-	remoteURL, err := getRemoteURL()
-	if err != nil {
-		return "", err
-	}
-
-	project, err := parseGitLabRemote(remoteURL)
-	if err != nil {
-		// Non-GitLab remote: use a sanitized version of the URL
-		sanitized := strings.NewReplacer("/", "-", ":", "-", "@", "-", ".", "-").Replace(remoteURL)
-		project = &gitlabProject{Path: sanitized}
-	}
-
-	// Turn "researchable/general/my-project" into "researchable-general-my-project"
-	filename := strings.ReplaceAll(project.Path, "/", "-") + ".json"
-
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		cacheDir = filepath.Join(os.Getenv("HOME"), ".cache")
+func run(args []string) error {
+	var err error
+	if conf, err = loadConfig(); err != nil {
+		fmt.Fprintf(stderr, "warning: ignoring config: %v\n", err)
+	} else if err := conf.validate(); err != nil {
+		fmt.Fprintf(stderr, "warning: invalid config: %v\n", err)
 	}
-
-	return filepath.Join(cacheDir, "gitlab-reviewer", filename), nil
-}
-
-// parseGitLabRemote extracts the host and project path from a git remote URL.
-// Supports both SSH and HTTPS formats:
-//
-//	git@gitlab.com:group/project.git
-//	https://gitlab.com/group/project.git
-func parseGitLabRemote(remoteURL string) (*gitlabProject, error) {
-	// Try SSH format: git@host:path.git
-	sshRe := regexp.MustCompile(`^git@([^:]+):(.+?)(?:\.git)?$`)
-	if m := sshRe.FindStringSubmatch(remoteURL); m != nil {
-		return &gitlabProject{Host: m[1], Path: m[2]}, nil
+	if _, err := envCacheTTL(); err != nil {
+		fmt.Fprintf(stderr, "warning: ignoring $GITLAB_REVIEWER_CACHE_TTL: %v\n", err)
 	}
 
-	// Try HTTPS format: https://host/path.git
-	u, err := url.Parse(remoteURL)
-	if err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" {
-		path := strings.TrimPrefix(u.Path, "/")
-		path = strings.TrimSuffix(path, ".git")
-		if path != "" {
-			return &gitlabProject{Host: u.Host, Path: path}, nil
+	if profile := profileArg(args); profile != "" {
+		if conf, err = conf.withProfile(profile); err != nil {
+			return err
 		}
+		options.profile = profile
 	}
 
-	return nil, fmt.Errorf("could not parse remote URL: %s", remoteURL)
-}
+	root := newRootCommand()
 
-// readPAT reads the GitLab personal access token from ~/.gitlab_pat.
-func readPAT() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("could not determine home directory: %w", err)
+	if len(args) > 0 && isVersionFlag(args[0]) {
+		fmt.Println(versionString())
+		return nil
 	}
 
-	data, err := os.ReadFile(filepath.Join(home, ".gitlab_pat"))
-	if err != nil {
-		return "", fmt.Errorf("could not read ~/.gitlab_pat: %w", err)
-	}
-
-	token := strings.TrimSpace(string(data))
-	if token == "" {
-		return "", fmt.Errorf("~/.gitlab_pat is empty")
-	}
-
-	return token, nil
-}
-
-func readCache(path string) ([]Member, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, err
-	}
-
-	if time.Since(info.ModTime()) > cacheTTL {
-		return nil, fmt.Errorf("cache is stale")
-	}
-
-	return readCacheIgnoreTTL(path)
-}
-
-func readCacheIgnoreTTL(path string) ([]Member, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var members []Member
-	if err := json.Unmarshal(data, &members); err != nil {
-		return nil, fmt.Errorf("parsing cache: %w", err)
-	}
-
-	if len(members) == 0 {
-		return nil, fmt.Errorf("cache is empty")
-	}
-
-	return members, nil
-}
-
-func writeCache(path string, members []Member) error {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if args, err = parseLeadingGlobalFlags(args); err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(members, "", "  ")
-	if err != nil {
-		return err
+	// Without a command, behave like "members" so that plain invocations
+	// (and the old -json/-refresh flags) keep working.
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelpFlag(args[0])) {
+		args = append([]string{"members"}, args...)
 	}
 
-	return os.WriteFile(path, data, 0o644)
+	return root.execute(nil, args)
 }
 
-func fetchFromGitLab() ([]Member, error) {
-	remoteURL, err := getRemoteURL()
-	if err != nil {
-		return nil, err
-	}
-
-	project, err := parseGitLabRemote(remoteURL)
-	if err != nil {
-		return nil, err
-	}
-
-	token, err := readPAT()
-	if err != nil {
-		return nil, err
-	}
-
-	// URL-encode the project path for the API call
-	encodedPath := url.PathEscape(project.Path)
-	apiURL := fmt.Sprintf("https://%s/api/v4/projects/%s/members/all?per_page=100", project.Host, encodedPath)
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		// Truncate body to avoid dumping entire HTML error pages
-		preview := string(body)
-		if len(preview) > 200 {
-			preview = preview[:200] + "..."
-		}
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, preview)
-	}
-
-	var apiMembers []apiMember
-	if err := json.Unmarshal(body, &apiMembers); err != nil {
-		return nil, fmt.Errorf("parsing API response: %w", err)
-	}
-
-	var members []Member
-	for _, am := range apiMembers {
-		if am.State != "active" {
-			continue
-		}
-		members = append(members, Member{
-			Name:     am.Name,
-			Username: am.Username,
-		})
-	}
-
-	if len(members) == 0 {
-		return nil, fmt.Errorf("no active members found")
-	}
-
-	return members, nil
+func isVersionFlag(arg string) bool {
+	return arg == "-version" || arg == "--version"
 }
 
-func fetchFromGitLog() ([]Member, error) {
-	out, err := exec.Command("git", "log", "--format=%aN").Output()
-	if err != nil {
-		return nil, fmt.Errorf("git log failed: %w", err)
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help":
+		return true
 	}
-
-	// Deduplicate names
-	seen := make(map[string]bool)
-	var members []Member
-
-	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		name = strings.TrimSpace(name)
-		if name == "" || seen[name] {
-			continue
-		}
-		seen[name] = true
-		members = append(members, Member{
-			Name:     name,
-			Username: "", // Unknown without GitLab API
-		})
-	}
-
-	return members, nil
+	return false
 }
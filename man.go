@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func newGenManCommand(root *command) *command {
+	fs := newFlagSet("gen-man")
+	dir := fs.String("dir", ".", "Directory to write the man pages to")
+
+	return &command{
+		name:    "gen-man",
+		summary: "Generate man pages",
+		help: `Generate a man page for the tool and for every subcommand, named like
+gitlab-reviewer-cache-show.1. Meant for packagers.`,
+		hidden: true,
+		flags:  fs,
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(*dir, 0o755); err != nil {
+				return err
+			}
+			return writeManPages(*dir, root, []string{root.name})
+		},
+	}
+}
+
+// writeManPages writes the man page for c and, recursively, for its
+// visible subcommands.
+func writeManPages(dir string, c *command, path []string) error {
+	f, err := os.Create(filepath.Join(dir, strings.Join(path, "-")+".1"))
+	if err != nil {
+		return err
+	}
+
+	writeManPage(f, c, path)
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, sub := range c.commands {
+		if sub.hidden {
+			continue
+		}
+		if err := writeManPages(dir, sub, append(path[:len(path):len(path)], sub.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeManPage(w io.Writer, c *command, path []string) {
+	name := strings.Join(path, "-")
+
+	fmt.Fprintf(w, ".TH %s 1 %q %q \"User Commands\"\n", strings.ToUpper(name), manDate().Format(time.DateOnly), "gitlab-reviewer "+versionOrDev())
+
+	fmt.Fprintf(w, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(c.summaryOrHelp()))
+
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s\n", roffEscape(c.synopsis(path)))
+
+	if c.help != "" {
+		fmt.Fprintf(w, ".SH DESCRIPTION\n")
+		for _, para := range strings.Split(strings.TrimSpace(c.help), "\n\n") {
+			if strings.HasPrefix(para, "  ") {
+				// Indented paragraphs are examples or tables
+				fmt.Fprintf(w, ".PP\n.nf\n%s\n.fi\n", roffEscape(para))
+			} else {
+				fmt.Fprintf(w, ".PP\n%s\n", roffEscape(para))
+			}
+		}
+	}
+
+	if len(c.commands) > 0 {
+		fmt.Fprintf(w, ".SH COMMANDS\n")
+		for _, sub := range c.commands {
+			if sub.hidden {
+				continue
+			}
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(sub.name), roffEscape(sub.summary))
+		}
+	}
+
+	if c.flags != nil && hasFlags(c.flags) {
+		fmt.Fprintf(w, ".SH OPTIONS\n")
+		c.flags.VisitAll(func(f *flag.Flag) {
+			argName, usage := flag.UnquoteUsage(f)
+			if argName != "" {
+				fmt.Fprintf(w, ".TP\n.BI \\-%s \" %s\"\n", roffEscape(f.Name), roffEscape(argName))
+			} else {
+				fmt.Fprintf(w, ".TP\n.B \\-%s\n", roffEscape(f.Name))
+			}
+			if !isZeroDefault(f) {
+				usage += fmt.Sprintf(" (default %s)", strconv.Quote(f.DefValue))
+			}
+			fmt.Fprintf(w, "%s\n", roffEscape(usage))
+		})
+	}
+
+	var seeAlso []string
+	if len(path) > 1 {
+		seeAlso = append(seeAlso, strings.Join(path[:len(path)-1], "-")+"(1)")
+	}
+	for _, sub := range c.commands {
+		if !sub.hidden {
+			seeAlso = append(seeAlso, name+"-"+sub.name+"(1)")
+		}
+	}
+	if len(seeAlso) > 0 {
+		fmt.Fprintf(w, ".SH SEE ALSO\n%s\n", roffEscape(strings.Join(seeAlso, ", ")))
+	}
+}
+
+func (c *command) summaryOrHelp() string {
+	if c.summary != "" {
+		return c.summary
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(c.help), ".")
+	return strings.Join(strings.Fields(first), " ")
+}
+
+// isZeroDefault reports whether a flag's default is its type's zero value,
+// in which case it is not worth mentioning.
+func isZeroDefault(f *flag.Flag) bool {
+	switch f.DefValue {
+	case "", "false", "0", "0s", "[]":
+		return true
+	}
+	return false
+}
+
+// manDate is the date stamped into man pages. SOURCE_DATE_EPOCH is honored
+// so that packaged pages are reproducible.
+func manDate() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+func versionOrDev() string {
+	if version != "" {
+		return version
+	}
+	return "dev"
+}
+
+// roffEscape escapes text for use in a roff document.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		// Lines starting with a control character would be read as requests
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,828 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Member struct {
+	ID          int    `json:"id,omitempty"` // GitLab user ID; 0 for git log contributors
+	Name        string `json:"name"`
+	Username    string `json:"username"`
+	State       string `json:"state,omitempty"`        // empty for git log contributors
+	AccessLevel int    `json:"access_level,omitempty"` // 0 when unknown
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	WebURL      string `json:"web_url,omitempty"` // profile page
+	Bot         bool   `json:"bot,omitempty"`
+	Membership  string `json:"membership,omitempty"` // see membershipDirect etc.; empty for git log contributors
+	ExpiresAt   string `json:"expires_at,omitempty"` // YYYY-MM-DD the membership ends, if it does
+
+	// Set by groupProjectMembers only
+	Projects []string `json:"projects,omitempty"`
+	// Set by withContributors only
+	Commits int `json:"commits,omitempty"`
+
+	// Only looked up on request, and never cached
+	Status       *userStatus `json:"status,omitempty"`
+	LastActivity string      `json:"last_activity,omitempty"` // YYYY-MM-DD
+	Timezone     string      `json:"timezone,omitempty"`      // UTC offset, e.g. "UTC+02:00"
+	Email        string      `json:"email,omitempty"`         // public email address
+	Identities   []identity  `json:"identities,omitempty"`    // LDAP and SAML accounts, if we may see them
+}
+
+// apiMember represents the relevant fields from the GitLab API response.
+type apiMember struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Username    string `json:"username"`
+	State       string `json:"state"`
+	AccessLevel int    `json:"access_level"`
+	AvatarURL   string `json:"avatar_url"`
+	WebURL      string `json:"web_url"`
+	Bot         bool   `json:"bot"`
+	ExpiresAt   string `json:"expires_at"` // YYYY-MM-DD, empty if it never expires
+
+	// The member's group SAML account, shown to owners of the group with SAML
+	GroupSAMLIdentity *struct {
+		ExternUID string `json:"extern_uid"`
+	} `json:"group_saml_identity"`
+
+	Membership string `json:"-"` // filled in by fetchAPIMembers
+}
+
+// How members came to be members of the project, from closest to farthest.
+const (
+	membershipDirect    = "direct"    // added to the project itself
+	membershipInherited = "inherited" // member of one of the project's groups
+	membershipShared    = "shared"    // member of a group the project is shared with
+	membershipNone      = "none"      // contributor in the git history who isn't a member
+)
+
+// closerMembership returns whichever of a and b is the closest, for members
+// who are members in several ways.
+func closerMembership(a, b string) string {
+	order := []string{membershipDirect, membershipInherited, membershipShared}
+	if i, j := slices.Index(order, a), slices.Index(order, b); j >= 0 && (i < 0 || j < i) {
+		return b
+	}
+	return a
+}
+
+// accessLevels maps GitLab role names to their numeric access levels.
+var accessLevels = map[string]int{
+	"guest":      10,
+	"planner":    15,
+	"reporter":   20,
+	"developer":  30,
+	"maintainer": 40,
+	"owner":      50,
+}
+
+// parseAccessLevel accepts a role name (e.g. "developer") or a numeric
+// access level and returns the numeric level.
+func parseAccessLevel(s string) (int, error) {
+	if level, ok := accessLevels[strings.ToLower(s)]; ok {
+		return level, nil
+	}
+	if level, err := strconv.Atoi(s); err == nil && level >= 0 {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unknown access level %q (want guest, planner, reporter, developer, maintainer, owner or a number)", s)
+}
+
+// accessLevelName returns the role name of level, e.g. "developer", the
+// number for levels without a name, or "" if the level is unknown.
+func accessLevelName(level int) string {
+	if level == 0 {
+		return ""
+	}
+	for name, l := range accessLevels {
+		if l == level {
+			return name
+		}
+	}
+	return strconv.Itoa(level)
+}
+
+// userStates are the states GitLab users can be in.
+var userStates = []string{"active", "blocked", "deactivated", "banned", "ldap_blocked", "blocked_pending_approval"}
+
+// checkUserState checks that s is one of the userStates, or "all".
+func checkUserState(s string) error {
+	if s != "all" && !slices.Contains(userStates, s) {
+		return fmt.Errorf("unknown state %q (want %s or all)", s, strings.Join(userStates, ", "))
+	}
+	return nil
+}
+
+// memberFilter narrows down a member list. The zero value matches everyone.
+type memberFilter struct {
+	Query     string            // case-insensitive substring of name or username
+	State     string            // user state to keep, or "" / "all" for every state
+	MinAccess int               // minimum access level; members with unknown level are kept
+	Exclude   []usernamePattern // usernames to leave out
+
+	IncludeBots bool   // keep bot users, which are left out by default
+	Self        string // username of the current user, to leave out if set
+	Direct      bool   // only keep direct members of the project
+
+	// Leave out memberships that expire within this time. Expired ones
+	// (still in the cache) are always left out.
+	ExpiringWithin time.Duration
+
+	Merge *mergeAccess // if set, only keep members allowed to merge
+}
+
+func (f memberFilter) match(m Member) bool {
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(m.Name), q) && !strings.Contains(strings.ToLower(m.Username), q) {
+			return false
+		}
+	}
+
+	if f.State != "" && f.State != "all" {
+		// Members without a state come from git log or an old cache,
+		// which only ever contained active members.
+		state := m.State
+		if state == "" {
+			state = "active"
+		}
+		if state != f.State {
+			return false
+		}
+	}
+
+	if f.MinAccess > 0 && m.AccessLevel != 0 && m.AccessLevel < f.MinAccess {
+		return false
+	}
+
+	if !f.IncludeBots && m.isBot() {
+		return false
+	}
+
+	if m.expiresWithin(f.ExpiringWithin) {
+		return false
+	}
+
+	if f.Direct && m.Membership != membershipDirect {
+		return false
+	}
+
+	if f.Merge != nil && !f.Merge.allows(m) {
+		return false
+	}
+
+	if f.Self != "" && strings.EqualFold(m.Username, f.Self) {
+		return false
+	}
+
+	for _, p := range f.Exclude {
+		if m.Username != "" && p.match(m.Username) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// accessTokenBotRe matches the usernames GitLab gives the bot users of
+// project and group access tokens, e.g. project_123_bot_0a1b2c3d.
+var accessTokenBotRe = regexp.MustCompile(`^(project|group)_\d+_bot(_[0-9a-f]+)?$`)
+
+// accessTokenKind returns "project" or "group" if username belongs to the
+// bot user of a project or group access token, and "" otherwise.
+func accessTokenKind(username string) string {
+	if m := accessTokenBotRe.FindStringSubmatch(username); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// isBot reports whether m is a bot user: one GitLab flags as such, or, for
+// members cached before that flag was, the bot user of a project or group
+// access token. These show up as members but can never review anything.
+func (m Member) isBot() bool {
+	return m.Bot || accessTokenKind(m.Username) != ""
+}
+
+// expiresWithin reports whether the membership of m ends within d from now,
+// or already has. GitLab removes members at the start (in UTC) of the day
+// their membership expires.
+func (m Member) expiresWithin(d time.Duration) bool {
+	if m.ExpiresAt == "" {
+		return false
+	}
+	expiry, err := time.Parse(time.DateOnly, m.ExpiresAt)
+	if err != nil {
+		return false
+	}
+	return time.Until(expiry) <= d
+}
+
+// earlierExpiry returns whichever of the expiry dates a and b comes first,
+// where "" means never.
+func earlierExpiry(a, b string) string {
+	if a == "" || (b != "" && b < a) {
+		return b
+	}
+	return a
+}
+
+// laterExpiry returns whichever of the expiry dates a and b comes last,
+// where "" means never.
+func laterExpiry(a, b string) string {
+	if a == "" || b == "" {
+		return ""
+	}
+	return max(a, b)
+}
+
+func filterMembers(members []Member, f memberFilter) []Member {
+	filtered := []Member{}
+	for _, m := range members {
+		if f.match(m) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+func newMembersCommand() *command {
+	fs := newFlagSet("members")
+	refresh := fs.Bool("refresh", false, "Force refresh the cache from GitLab API")
+	jsonOut := fs.Bool("json", conf.jsonOutput(), "Output as JSON instead of TSV")
+	query := fs.String("query", "", "Only list members whose name or username matches `text` (searched server-side)")
+	fs.StringVar(query, "search", "", "Same as -query")
+	state := fs.String("state", "active", "Only list members in this user `state` ("+strings.Join(userStates, ", ")+" or all)")
+	minAccess := fs.String("min-access", conf.MinAccess, "Only list members with at least this access `level` (e.g. developer)")
+	fields := fs.String("fields", strings.Join(conf.fields(), ","), "Comma-separated TSV `columns`: "+strings.Join(fieldNames(), ", "))
+	status := fs.Bool("status", false, "Look up each member's status message and availability (one API request per member)")
+	skipBusy := fs.Bool("skip-busy", false, "Leave out members whose status says they are busy or out of office")
+	var skipInactive duration
+	fs.TextVar(&skipInactive, "skip-inactive", duration(0), "Leave out members who have not been active on GitLab for this `long` (e.g. 90d)")
+	includeBots := fs.Bool("include-bots", false, "Also list bot users, such as those of access tokens")
+	excludeSelf := fs.Bool("exclude-self", false, "Leave out the user the token belongs to")
+	direct := fs.Bool("direct", false, "Only list direct members of the project, not those inherited from its groups or shared groups")
+	var skipExpiring duration
+	fs.TextVar(&skipExpiring, "skip-expiring", duration(0), "Leave out members whose membership expires within this `long` (e.g. 14d)")
+	sortBy := fs.String("sort", "", "Sort by `key`: "+strings.Join(sortKeyNames(), ", ")+" (default: as GitLab returns them)")
+	reverse := fs.Bool("reverse", false, "Reverse the sort order")
+	limit := fs.Int("limit", 0, "List at most `n` members, after filtering and sorting (default: all)")
+	withCommits := fs.Bool("contributors", false, "Count each member's commits in the git history, and also list contributors who aren't members")
+	projects := fs.Bool("projects", false, "With --group, list the members of every project in the group instead, with the projects each is a member of (one API request per project)")
+	canMerge := fs.Bool("can-merge", false, "Only list members allowed to merge into the target branch of the current branch's merge request, or else the default branch")
+
+	return &command{
+		name:    "members",
+		summary: "List members of the current project",
+		help: `List members of the GitLab project for the current repository as
+name<TAB>username lines. This is the default command.`,
+		flags: fs,
+		flagValues: map[string]func() []string{
+			"fields":     fieldNames,
+			"min-access": completeAccessLevels,
+			"sort":       sortKeyNames,
+			"state":      func() []string { return append(slices.Clone(userStates), "all") },
+		},
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+
+			if err := checkUserState(*state); err != nil {
+				return err
+			}
+			if *limit < 0 {
+				return fmt.Errorf("-limit must not be negative")
+			}
+			if _, ok := sortKeys[*sortBy]; !ok && *sortBy != "" {
+				return fmt.Errorf("unknown sort key %q (want %s)", *sortBy, strings.Join(sortKeyNames(), ", "))
+			}
+			// The query is applied by getMembers, server-side if possible
+			exclude, err := excludePatterns()
+			if err != nil {
+				return err
+			}
+			filter := memberFilter{
+				State:          *state,
+				Exclude:        exclude,
+				IncludeBots:    *includeBots,
+				Direct:         *direct,
+				ExpiringWithin: time.Duration(skipExpiring),
+			}
+			if *excludeSelf {
+				filter.Self = lookUpSelf()
+			}
+			if *canMerge {
+				if filter.Merge, err = lookUpMergeAccess(""); err != nil {
+					return err
+				}
+			}
+			if *minAccess != "" {
+				level, err := parseAccessLevel(*minAccess)
+				if err != nil {
+					return err
+				}
+				filter.MinAccess = level
+			}
+			columns, err := parseFields(*fields)
+			if err != nil {
+				return err
+			}
+
+			var members []Member
+			if *projects {
+				members, err = groupProjectMembers(*query, *state)
+			} else {
+				members, err = getMembers(*refresh, *query, *state)
+			}
+			if err != nil {
+				return err
+			}
+			if *withCommits {
+				if members, err = withContributors(members); err != nil {
+					return err
+				}
+			}
+			members = filterMembers(members, filter)
+
+			lookups := newMemberLookups(columns, *skipBusy, time.Duration(skipInactive))
+			lookups.status = lookups.status || *status
+			lookups.activity = lookups.activity || *sortBy == "activity"
+			kept := []Member{}
+			for _, m := range lookups.apply(members) {
+				if lookups.keep(m) {
+					kept = append(kept, m)
+				}
+			}
+			if *sortBy != "" {
+				sortMembers(kept, *sortBy)
+			}
+			if *reverse {
+				slices.Reverse(kept)
+			}
+			if *limit > 0 && len(kept) > *limit {
+				kept = kept[:*limit]
+			}
+
+			return printMembers(os.Stdout, kept, *jsonOut, columns)
+		},
+	}
+}
+
+func printMembers(w io.Writer, members []Member, jsonOut bool, fields []string) error {
+	if jsonOut {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(members); err != nil {
+			return fmt.Errorf("encoding json: %w", err)
+		}
+		return nil
+	}
+
+	for _, m := range members {
+		fmt.Fprintln(w, memberRow(m, fields))
+	}
+	return nil
+}
+
+// memberFields are the columns of TSV output, selected with -fields.
+var memberFields = map[string]func(Member) string{
+	"access":     func(m Member) string { return accessLevelName(m.AccessLevel) },
+	"avatar_url": func(m Member) string { return m.AvatarURL },
+	"busy": func(m Member) string {
+		if m.Status.away() {
+			return "busy"
+		}
+		return ""
+	},
+	"commits": func(m Member) string {
+		if m.Commits == 0 {
+			return ""
+		}
+		return strconv.Itoa(m.Commits)
+	},
+	"email":      func(m Member) string { return m.Email },
+	"expires_at": func(m Member) string { return m.ExpiresAt },
+	"id": func(m Member) string {
+		if m.ID == 0 {
+			return ""
+		}
+		return strconv.Itoa(m.ID)
+	},
+	"identities": func(m Member) string {
+		ids := make([]string, len(m.Identities))
+		for i, id := range m.Identities {
+			ids[i] = id.String()
+		}
+		return strings.Join(ids, ",")
+	},
+	"last_activity": func(m Member) string { return m.LastActivity },
+	"membership":    func(m Member) string { return m.Membership },
+	"name":          func(m Member) string { return m.Name },
+	"projects":      func(m Member) string { return strings.Join(m.Projects, ",") },
+	"state":         func(m Member) string { return m.State },
+	"status":        func(m Member) string { return m.Status.String() },
+	"timezone":      func(m Member) string { return m.Timezone },
+	"username":      func(m Member) string { return m.Username },
+	"web_url":       func(m Member) string { return m.WebURL },
+}
+
+// sortKeys compare members by the keys of -sort, in ascending order.
+var sortKeys = map[string]func(a, b Member) int{
+	"access":   func(a, b Member) int { return cmp.Compare(a.AccessLevel, b.AccessLevel) },
+	"activity": func(a, b Member) int { return strings.Compare(a.LastActivity, b.LastActivity) },
+	"commits":  func(a, b Member) int { return cmp.Compare(a.Commits, b.Commits) },
+	"name": func(a, b Member) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	},
+	"username": func(a, b Member) int {
+		return strings.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+	},
+}
+
+// sortKeyNames returns the names of the sortKeys.
+func sortKeyNames() []string {
+	return slices.Sorted(maps.Keys(sortKeys))
+}
+
+// sortMembers sorts members by one of the sortKeys, and by username where
+// they are equal.
+func sortMembers(members []Member, key string) {
+	slices.SortStableFunc(members, func(a, b Member) int {
+		return cmp.Or(sortKeys[key](a, b), sortKeys["username"](a, b))
+	})
+}
+
+// defaultFields are the TSV columns unless configured otherwise.
+var defaultFields = []string{"name", "username"}
+
+// fieldNames returns the names of the memberFields.
+func fieldNames() []string {
+	return slices.Sorted(maps.Keys(memberFields))
+}
+
+// parseFields parses a comma-separated list of memberFields.
+func parseFields(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := memberFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q (want %s)", field, strings.Join(fieldNames(), ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// memberRow returns the TSV line of m with the given fields.
+func memberRow(m Member, fields []string) string {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = memberFields[field](m)
+	}
+	return strings.Join(values, "\t")
+}
+
+// getMembers returns the project members from the cache, the GitLab API or
+// git log, in that order of preference. A non-empty query is passed to the
+// API as a server-side search and its results are never cached; if the API
+// is unreachable the full member list is searched locally instead. The
+// state filter of a search is passed on too, though only as a hint: the
+// caller still has to filter the results.
+//
+// Unless forceRefresh is set, the API is skipped while it is known to be
+// down (see checkBreaker).
+func getMembers(forceRefresh bool, query, state string) ([]Member, error) {
+	var breakerErr error
+	if project, err := currentProject(); err == nil && !forceRefresh {
+		breakerErr = checkBreaker(project.Host)
+	}
+
+	if query != "" {
+		err := breakerErr
+		if err == nil {
+			members, fetchErr := fetchFromGitLab(query, state)
+			if fetchErr == nil {
+				return members, nil
+			}
+			err = fetchErr
+		}
+		fmt.Fprintf(stderr, "warning: GitLab member search failed: %v\n", err)
+
+		members, err := getMembers(false, "", "")
+		if err != nil {
+			return nil, err
+		}
+		return filterMembers(members, memberFilter{Query: query}), nil
+	}
+
+	cachePath, cachePathErr := getCachePath()
+
+	// Try to use cache if not forcing refresh and cache path is available
+	if cachePathErr == nil && !forceRefresh {
+		members, err := readCache(cachePath)
+		if err == nil {
+			return members, nil
+		}
+		// Cache miss or stale, continue to refresh
+	}
+
+	// Try GitLab API directly
+	var members []Member
+	var err error
+	if breakerErr != nil {
+		err = breakerErr
+	} else if cachePathErr == nil {
+		members, err = refreshFromGitLab(cachePath)
+		if members != nil && err != nil {
+			// Writing the cache is best effort
+			fmt.Fprintf(stderr, "warning: could not write cache: %v\n", err)
+			err = nil
+		}
+	} else {
+		members, err = fetchFromGitLab("", "")
+	}
+	if err == nil {
+		return members, nil
+	}
+
+	if ctx.Err() != nil {
+		// Interrupted; don't fall back to anything
+		return nil, ctx.Err()
+	}
+	fmt.Fprintf(stderr, "warning: GitLab API failed: %v\n", err)
+	if errors.Is(err, errNoToken) {
+		fmt.Fprintf(stderr, "hint: run \"gitlab-reviewer init\" to set up a token\n")
+	}
+
+	// Try stale cache
+	if cachePathErr == nil {
+		members, staleErr := readCacheIgnoreTTL(cachePath)
+		if staleErr == nil {
+			fmt.Fprintf(stderr, "warning: using stale cache\n")
+			return members, nil
+		}
+	}
+
+	// Last resort: git log
+	fmt.Fprintf(stderr, "warning: falling back to git log contributors (usernames only where earlier runs found them on GitLab)\n")
+	members, gitLogErr := fetchFromGitLog()
+	if gitLogErr != nil {
+		fmt.Fprintf(stderr, "warning: git log failed: %v\n", gitLogErr)
+		return []Member{}, nil
+	}
+
+	return members, nil
+}
+
+// fetchFromGitLab fetches all project members, including inherited ones and
+// those that are not active. A non-empty query restricts the results to
+// members matching it by name, username or email. See fetchAPIMembers for
+// state.
+func fetchFromGitLab(query, state string) ([]Member, error) {
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+
+	members, _, err := fetchMembers(client, query, state)
+	recordAPIResult(client.project.Host, err)
+	return members, err
+}
+
+// refreshFromGitLab fetches all project members into the cache at
+// cachePath. When the cache has the ETags of the pages fetched last time,
+// conditional requests ask GitLab whether any changed first; if none did,
+// the cache is only marked fresh. If the members were fetched but the cache
+// could not be written, both are returned.
+//
+// Refreshes of the same cache are serialized with lockFile. If another
+// process updated the cache while we waited for it, its members are used.
+func refreshFromGitLab(cachePath string) ([]Member, error) {
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if unlock, err := lockFile(cachePath); err != nil {
+		verbosef("refreshing without a lock: %v", err)
+	} else {
+		defer unlock()
+		if info, err := os.Stat(cachePath); err == nil && info.ModTime().After(start) {
+			if members, err := readCacheIgnoreTTL(cachePath); err == nil {
+				verbosef("members were just refreshed by another gitlab-reviewer")
+				return members, nil
+			}
+		}
+	}
+
+	if tags, err := readETags(cachePath); err == nil && client.notModified(tags) {
+		if members, err := readCacheIgnoreTTL(cachePath); err == nil {
+			recordAPIResult(client.project.Host, nil)
+			verbosef("members unchanged since the last refresh")
+			now := time.Now()
+			return members, os.Chtimes(cachePath, now, now)
+		}
+	}
+
+	members, tags, err := fetchMembers(client, "", "")
+	recordAPIResult(client.project.Host, err)
+	if err != nil {
+		return nil, err
+	}
+	return members, writeCache(cachePath, members, tags)
+}
+
+// fetchMembers fetches the members matching query (all if it is empty) and
+// the ETags of the pages they came from.
+func fetchMembers(client *gitlabClient, query, state string) ([]Member, []pageTag, error) {
+	apiMembers, tags, err := fetchAPIMembers(client, query, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if options.sharedGroups {
+		shared, err := fetchSharedGroupMembers(client, query, state)
+		if err != nil {
+			return nil, nil, err
+		}
+		apiMembers = mergeMembers(apiMembers, shared)
+		// The ETags don't cover the groups, so refresh in full every time
+		tags = nil
+	}
+
+	members := []Member{}
+	for _, am := range apiMembers {
+		var identities []identity
+		if am.GroupSAMLIdentity != nil {
+			identities = []identity{{Provider: "group_saml", ExternUID: am.GroupSAMLIdentity.ExternUID}}
+		}
+		members = append(members, Member{
+			ID:          am.ID,
+			Name:        am.Name,
+			Username:    am.Username,
+			State:       am.State,
+			AccessLevel: am.AccessLevel,
+			AvatarURL:   am.AvatarURL,
+			WebURL:      am.WebURL,
+			Bot:         am.Bot,
+			Membership:  am.Membership,
+			ExpiresAt:   am.ExpiresAt,
+			Identities:  identities,
+		})
+	}
+
+	// An empty result is only suspicious for the unfiltered list
+	if len(members) == 0 && query == "" {
+		return nil, nil, fmt.Errorf("no members found")
+	}
+
+	return members, tags, nil
+}
+
+// fetchAPIMembers fetches the members matching query from the REST API.
+// With --api graphql they are fetched with GraphQL instead, falling back to
+// REST if that fails (e.g. on instances without GraphQL). GraphQL responses
+// carry no ETags, and CI job tokens are not accepted by it at all. Group
+// members (see --group) are always fetched with REST.
+//
+// A state of "active" asks GitLab to leave out members awaiting approval,
+// where it supports that (the REST API of Premium and Ultimate instances).
+// Blocked and deactivated users are listed regardless.
+func fetchAPIMembers(client *gitlabClient, query, state string) ([]apiMember, []pageTag, error) {
+	if options.api == "graphql" && !client.cred.job && !client.project.Group {
+		members, err := fetchMembersGraphQL(client, query)
+		if err == nil || ctx.Err() != nil {
+			return members, nil, err
+		}
+		fmt.Fprintf(stderr, "warning: GraphQL failed, falling back to REST: %v\n", err)
+	}
+
+	params := url.Values{}
+	if query != "" {
+		params.Set("query", query)
+	}
+	if state == "active" {
+		params.Set("state", state)
+	}
+	members, tags, err := getAllTagged[apiMember](client, client.projectPath("/members/all"), params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// /members/all doesn't say where a membership comes from, but /members
+	// lists only the direct ones
+	direct, directTags, err := getAllTagged[apiMember](client, client.projectPath("/members"), params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching direct members: %w", err)
+	}
+	isDirect := make(map[int]bool)
+	for _, m := range direct {
+		isDirect[m.ID] = true
+	}
+	for i := range members {
+		members[i].Membership = membershipInherited
+		if isDirect[members[i].ID] {
+			members[i].Membership = membershipDirect
+		}
+	}
+	return members, append(tags, directTags...), nil
+}
+
+// fetchSharedGroupMembers fetches the members of the groups the project is
+// shared with, matching query and state like fetchAPIMembers. Their access
+// level is capped at the one the project was shared with.
+func fetchSharedGroupMembers(client *gitlabClient, query, state string) ([]apiMember, error) {
+	var project struct {
+		SharedWithGroups []struct {
+			GroupID          int    `json:"group_id"`
+			GroupFullPath    string `json:"group_full_path"`
+			GroupAccessLevel int    `json:"group_access_level"`
+			ExpiresAt        string `json:"expires_at"` // when the project stops being shared
+		} `json:"shared_with_groups"`
+	}
+	if err := client.get(client.projectPath(""), nil, &project); err != nil {
+		return nil, fmt.Errorf("looking up the groups %s is shared with: %w", client.project.Path, err)
+	}
+
+	params := url.Values{}
+	if query != "" {
+		params.Set("query", query)
+	}
+	if state == "active" {
+		params.Set("state", state)
+	}
+
+	groups := project.SharedWithGroups
+	members := make([][]apiMember, len(groups))
+	err := batch(len(groups), func(i int) error {
+		path := "/groups/" + strconv.Itoa(groups[i].GroupID) + "/members/all"
+		list, err := getAll[apiMember](client, path, params)
+		if err != nil {
+			return fmt.Errorf("fetching the members of %s: %w", groups[i].GroupFullPath, err)
+		}
+		for j := range list {
+			list[j].AccessLevel = min(list[j].AccessLevel, groups[i].GroupAccessLevel)
+			list[j].ExpiresAt = earlierExpiry(list[j].ExpiresAt, groups[i].ExpiresAt)
+			list[j].Membership = membershipShared
+		}
+		members[i] = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(members...), nil
+}
+
+// mergeMembers adds the members of extra that aren't in members already.
+// Members that are in both keep their closest membership and highest
+// access level.
+func mergeMembers(members, extra []apiMember) []apiMember {
+	index := make(map[int]int)
+	for i, m := range members {
+		index[m.ID] = i
+	}
+	for _, m := range extra {
+		i, ok := index[m.ID]
+		if !ok {
+			index[m.ID] = len(members)
+			members = append(members, m)
+			continue
+		}
+		members[i].AccessLevel = max(members[i].AccessLevel, m.AccessLevel)
+		members[i].Membership = closerMembership(members[i].Membership, m.Membership)
+	}
+	return members
+}
+
+// fetchFromGitLog lists the authors in the git history, most commits
+// first, with their GitLab users where resolveAuthors finds them in the
+// cache. It is the fallback for when the API failed, so it doesn't search
+// the API for the others.
+func fetchFromGitLog() ([]Member, error) {
+	list, err := contributors()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	for _, c := range list {
+		members = append(members, Member{Name: c.name, Email: c.email})
+	}
+
+	return resolveAuthors(members, false), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apiProtectedBranch is a protected branch rule. Its name may contain *
+// wildcards, matching several branches.
+type apiProtectedBranch struct {
+	Name              string `json:"name"`
+	MergeAccessLevels []struct {
+		AccessLevel int `json:"access_level"` // 0 for no one
+		UserID      int `json:"user_id"`
+		GroupID     int `json:"group_id"`
+	} `json:"merge_access_levels"`
+}
+
+// mergeAccess says who may merge into a branch: members with at least a
+// role, and specific users.
+type mergeAccess struct {
+	Branch    string
+	MinAccess int          // lowest access level allowed to merge, 0 if no role is
+	Users     map[int]bool // IDs of users allowed regardless of their role
+}
+
+// allows reports whether m may merge into the branch. Members with an
+// unknown access level, from git log, are kept like memberFilter.MinAccess
+// keeps them, but contributors who aren't members can't merge.
+func (a *mergeAccess) allows(m Member) bool {
+	if m.Membership == membershipNone {
+		return false
+	}
+	if m.AccessLevel == 0 {
+		return true
+	}
+	if a.Users[m.ID] {
+		return true
+	}
+	return a.MinAccess > 0 && m.AccessLevel >= a.MinAccess
+}
+
+// lookUpMergeAccess finds out who may merge into the target branch: base
+// if given (without its remote), or else the target of the open merge
+// request for the current branch, or else the project's default branch.
+//
+// Unprotected branches can be merged into by developers. Where protected
+// branch rules match, the most permissive one applies, like in GitLab.
+func lookUpMergeAccess(base string) (*mergeAccess, error) {
+	if options.group != "" {
+		return nil, fmt.Errorf("-can-merge needs a project, not a group; drop --group")
+	}
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBreaker(client.project.Host); err != nil {
+		return nil, err
+	}
+
+	access, err := fetchMergeAccess(client, base)
+	recordAPIResult(client.project.Host, err)
+	return access, err
+}
+
+func fetchMergeAccess(client *gitlabClient, base string) (*mergeAccess, error) {
+	branch, err := targetBranch(client, base)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := getAll[apiProtectedBranch](client, client.projectPath("/protected_branches"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing the protected branches of %s: %w", client.project.Path, err)
+	}
+
+	access := &mergeAccess{Branch: branch, Users: make(map[int]bool)}
+	var groups []int
+	protected := false
+	for _, rule := range rules {
+		if !branchPatternRe(rule.Name).MatchString(branch) {
+			continue
+		}
+		protected = true
+		for _, level := range rule.MergeAccessLevels {
+			switch {
+			case level.UserID != 0:
+				access.Users[level.UserID] = true
+			case level.GroupID != 0:
+				groups = append(groups, level.GroupID)
+			case level.AccessLevel > 0 && (access.MinAccess == 0 || level.AccessLevel < access.MinAccess):
+				access.MinAccess = level.AccessLevel
+			}
+		}
+	}
+	if !protected {
+		access.MinAccess = accessLevels["developer"]
+		verbosef("%s is not protected, developers can merge into it", branch)
+		return access, nil
+	}
+
+	lists := make([][]apiMember, len(groups))
+	err = batch(len(groups), func(i int) error {
+		list, err := getAll[apiMember](client, "/groups/"+strconv.Itoa(groups[i])+"/members/all", nil)
+		if err != nil {
+			return fmt.Errorf("fetching the members of group %d, who may merge into %s: %w", groups[i], branch, err)
+		}
+		lists[i] = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		for _, am := range list {
+			access.Users[am.ID] = true
+		}
+	}
+	return access, nil
+}
+
+// targetBranch returns the branch merged into, as described for
+// lookUpMergeAccess.
+func targetBranch(client *gitlabClient, base string) (string, error) {
+	if base != "" {
+		base = strings.TrimPrefix(base, "refs/heads/")
+		base = strings.TrimPrefix(base, "refs/remotes/")
+		for _, remote := range gitRemotes() {
+			if branch, ok := strings.CutPrefix(base, remote+"/"); ok {
+				return branch, nil
+			}
+		}
+		return base, nil
+	}
+
+	mr, err := currentMergeRequest(client)
+	if err == nil {
+		return mr.TargetBranch, nil
+	}
+	verbosef("using the default branch: %v", err)
+
+	var project struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := client.get(client.projectPath(""), nil, &project); err != nil {
+		return "", fmt.Errorf("looking up the default branch of %s: %w", client.project.Path, err)
+	}
+	if project.DefaultBranch == "" {
+		return "", fmt.Errorf("%s has no default branch", client.project.Path)
+	}
+	return project.DefaultBranch, nil
+}
+
+// branchPatternRe returns a regular expression matching the branch names a
+// protected branch name matches, where * stands for any characters.
+func branchPatternRe(name string) *regexp.Regexp {
+	parts := strings.Split(name, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// netrcPath returns the location of the user's netrc file, honoring the
+// NETRC environment variable like curl does.
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+// readNetrcToken returns the password netrc holds for host, falling back to
+// the "default" entry.
+func readNetrcToken(host string) (string, error) {
+	path, err := netrcPath()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if password := parseNetrc(string(data), host); password != "" {
+		return password, nil
+	}
+	return "", fmt.Errorf("no password for %s in %s", host, path)
+}
+
+// parseNetrc returns the password of the machine entry for host, or of the
+// default entry if there is none.
+func parseNetrc(data, host string) string {
+	fields := strings.Fields(data)
+
+	var machine, defaultPassword string
+	isDefault := false
+
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				machine, isDefault = fields[i], false
+			}
+		case "default":
+			machine, isDefault = "", true
+		case "login", "account":
+			i++
+		case "password":
+			if i+1 >= len(fields) {
+				continue
+			}
+			i++
+			if machine == host {
+				return fields[i]
+			}
+			if isDefault {
+				defaultPassword = fields[i]
+			}
+		case "macdef":
+			// Macro definitions run until an empty line; they never hold
+			// credentials, so skip to the next entry
+			machine, isDefault = "", false
+			for i+1 < len(fields) && fields[i+1] != "machine" && fields[i+1] != "default" {
+				i++
+			}
+		}
+	}
+
+	return defaultPassword
+}
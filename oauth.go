@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// oauthScope is requested for device-flow logins; assign needs api.
+const oauthScope = "api"
+
+// deviceAuthorization is GitLab's response to a device authorization
+// request (RFC 8628).
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// oauthToken is a response from the OAuth token endpoint.
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// credentials returns the credentials file entry for t.
+func (t *oauthToken) credentials(clientID string) hostCredentials {
+	created := time.Now()
+	if t.CreatedAt > 0 {
+		created = time.Unix(t.CreatedAt, 0)
+	}
+	return hostCredentials{
+		Token:        t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		ExpiresAt:    created.Add(time.Duration(t.ExpiresIn) * time.Second),
+		ClientID:     clientID,
+	}
+}
+
+// oauthError is an error response from an OAuth endpoint.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// oauthClientID returns the OAuth application ID configured for host.
+func (c *Config) oauthClientID(host string) string {
+	return c.Hosts[host].OAuthClientID
+}
+
+// oauthTokenInfo returns the scopes of the OAuth token the client
+// authenticates with.
+func oauthTokenInfo(client *gitlabClient) (*apiToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/oauth/token/info", client.host), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set(client.cred.header())
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OAuth request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OAuth token info returned status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Scope []string `json:"scope"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("parsing OAuth response: %w", err)
+	}
+	return &apiToken{Name: "OAuth", Scopes: info.Scope, Active: true}, nil
+}
+
+// oauthLogin runs the device authorization flow against host: the user
+// confirms the code in their browser while we poll for the token.
+func oauthLogin(host, clientID string) (*oauthToken, error) {
+	var auth deviceAuthorization
+	err := oauthPost(host, "authorize_device", url.Values{
+		"client_id": {clientID},
+		"scope":     {oauthScope},
+	}, &auth)
+	if err != nil {
+		return nil, fmt.Errorf("starting device authorization: %w", err)
+	}
+
+	fmt.Fprintf(stderr, "Open %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	if isTerminal(os.Stdin) {
+		target := auth.VerificationURIComplete
+		if target == "" {
+			target = auth.VerificationURI
+		}
+		if err := openBrowser(target); err != nil {
+			fmt.Fprintf(stderr, "warning: could not open browser: %v\n", err)
+		}
+	}
+	fmt.Fprintf(stderr, "Waiting for authorization...\n")
+
+	interval := time.Duration(max(auth.Interval, 5)) * time.Second
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		if err := sleep(interval); err != nil {
+			return nil, err
+		}
+
+		var token oauthToken
+		err := oauthPost(host, "token", url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {auth.DeviceCode},
+			"client_id":   {clientID},
+		}, &token)
+		if err == nil {
+			return &token, nil
+		}
+
+		var oerr *oauthError
+		if !errors.As(err, &oerr) {
+			return nil, err
+		}
+		switch oerr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("authorization failed: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("the code expired before it was confirmed")
+}
+
+// refreshOAuthToken exchanges refreshToken for a new access token.
+func refreshOAuthToken(host, clientID, refreshToken string) (*oauthToken, error) {
+	var token oauthToken
+	err := oauthPost(host, "token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {clientID},
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// oauthPost posts form to an OAuth endpoint of host and decodes the JSON
+// response into out. Error responses are returned as *oauthError.
+func oauthPost(host, endpoint string, form url.Values, out any) error {
+	oauthURL := fmt.Sprintf("https://%s/oauth/%s", conf.apiHost(&gitlabProject{Host: host}), endpoint)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(host).Do(req)
+	if err != nil {
+		return fmt.Errorf("OAuth request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var oerr oauthError
+		if json.Unmarshal(body, &oerr) == nil && oerr.Code != "" {
+			return &oerr
+		}
+		preview := string(body)
+		if len(preview) > 200 {
+			preview = preview[:200] + "..."
+		}
+		return fmt.Errorf("OAuth endpoint returned status %d: %s", resp.StatusCode, preview)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("parsing OAuth response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options holds the values of the global flags, which every command
+// accepts, both before and after the command name.
+var options struct {
+	api          string
+	cacheDir     string
+	debugHTTP    bool
+	dir          string
+	group        string
+	host         string
+	insecure     bool
+	perPage      int
+	profile      string
+	project      string
+	proxy        string
+	record       string
+	sharedGroups bool
+	since        string
+	remote       string
+	replay       string
+	superproject bool
+	tokenFile    string
+	timeout      time.Duration
+	ttl          duration
+	upstream     bool
+	verbose      bool
+}
+
+// globalFlagValues completes the values of global flags.
+var globalFlagValues = map[string]func() []string{
+	"api":     func() []string { return []string{"rest", "graphql"} },
+	"profile": profileNames,
+	"remote":  gitRemotes,
+}
+
+// addGlobalFlags registers the global flags on fs.
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.Func("api", "Use the `api` \"rest\" (default) or \"graphql\" to fetch members; GraphQL falls back to REST on failure", func(s string) error {
+		if s != "rest" && s != "graphql" {
+			return fmt.Errorf("want rest or graphql")
+		}
+		options.api = s
+		return nil
+	})
+	fs.StringVar(&options.cacheDir, "cache-dir", "", "Keep the cache in `dir` instead of the user cache directory (default: $GITLAB_REVIEWER_CACHE_DIR)")
+	fs.BoolVar(&options.debugHTTP, "debug-http", false, "Trace HTTP requests and responses on stderr (tokens are masked), e.g. for bug reports")
+	fs.StringVar(&options.dir, "C", "", "Run git as if started in `path`, like git -C")
+	fs.StringVar(&options.group, "group", "", "Use the members of the GitLab group at `path` (e.g. group/subgroup), including those inherited from parent groups, instead of a project's")
+	fs.StringVar(&options.host, "host", "", "GitLab `host` to use instead of the git remote's")
+	fs.BoolVar(&options.insecure, "insecure", false, "Don't verify TLS certificates (for development instances with self-signed ones; unsafe)")
+	fs.Func("per-page", "Fetch `n` items per API request, up to 100 (default 100); smaller pages help slow instances", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			return fmt.Errorf("want a number from 1 to 100")
+		}
+		options.perPage = n
+		return nil
+	})
+	fs.StringVar(&options.project, "project", "", "GitLab project `path` (e.g. group/project) to use instead of detecting it from git")
+	fs.StringVar(&options.profile, "profile", "", "Use the settings of the named `profile` from the config (default: $GITLAB_REVIEWER_PROFILE)")
+	fs.StringVar(&options.proxy, "proxy", "", "Send API requests through this proxy `URL` (http://, https:// or socks5://; default: the proxy setting or $HTTPS_PROXY)")
+	fs.BoolVar(&options.sharedGroups, "include-shared-groups", false, "Also use the members of groups the project is shared with, which /members/all may leave out")
+	fs.StringVar(&options.record, "record", "", "Write every API request and response to `file`, with tokens masked, e.g. for a bug report")
+	fs.StringVar(&options.replay, "replay", "", "Answer API requests from a `file` written by --record instead of contacting GitLab")
+	fs.StringVar(&options.remote, "remote", "", "Git `remote` to detect the GitLab project from (default: the remote setting, or origin)")
+	fs.Func("since", "List git log contributors active in this `period`, e.g. 6m, 2y or all (default: the git_since setting, or 12m)", func(s string) error {
+		if _, err := parseSince(s, time.Now()); err != nil {
+			return err
+		}
+		options.since = s
+		return nil
+	})
+	fs.BoolVar(&options.superproject, "superproject", false, "Inside a git submodule, use the superproject's remote and history instead of the submodule's")
+	fs.BoolVar(&options.upstream, "upstream", false, "If the project is a fork, use the project it was forked from")
+	fs.BoolVar(&options.verbose, "verbose", false, "Report retries and the remaining API rate limit on stderr")
+	fs.DurationVar(&options.timeout, "timeout", 0, "Give up on HTTP requests after this `duration` (default: the timeout setting, or 10s)")
+	fs.TextVar(&options.ttl, "ttl", duration(0), "Cache member lists for this `long`, e.g. 1h or 7d (default: $GITLAB_REVIEWER_CACHE_TTL, the cache_ttl setting, or 1d)")
+	fs.StringVar(&options.tokenFile, "token-file", "", "Read and store tokens in this credentials `file` (default: $GITLAB_REVIEWER_TOKEN_FILE or token_file)")
+}
+
+// addGlobalFlagsTree registers the global flags on every runnable command
+// in the tree rooted at c.
+func addGlobalFlagsTree(c *command) {
+	if c.run != nil && !c.rawArgs {
+		if c.flags == nil {
+			c.flags = newFlagSet(c.name)
+		}
+		addGlobalFlags(c.flags)
+	}
+	for _, sub := range c.commands {
+		addGlobalFlagsTree(sub)
+	}
+}
+
+// profileArg returns the profile selected with --profile anywhere in args
+// or with $GITLAB_REVIEWER_PROFILE. It is needed before the commands are
+// set up, since their flag defaults come from the config.
+func profileArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "profile" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("GITLAB_REVIEWER_PROFILE")
+}
+
+// perPage returns the page size for list requests: --per-page, or the
+// maximum GitLab allows.
+func perPage() int {
+	if options.perPage != 0 {
+		return options.perPage
+	}
+	return 100
+}
+
+// verbosef prints a diagnostic message when running with --verbose.
+func verbosef(format string, args ...any) {
+	if options.verbose {
+		fmt.Fprintf(stderr, format+"\n", args...)
+	}
+}
+
+// parseLeadingGlobalFlags parses the global flags given before the command
+// name and returns the remaining arguments. It stops at the first argument
+// that is not a global flag, leaving e.g. "-json" to the default command.
+func parseLeadingGlobalFlags(args []string) ([]string, error) {
+	fs := newFlagSet("gitlab-reviewer")
+	addGlobalFlags(fs)
+
+	n := 0
+	for n < len(args) && strings.HasPrefix(args[n], "-") && args[n] != "--" {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[n], "-"), "=")
+		f := fs.Lookup(name)
+		if f == nil {
+			break
+		}
+		n++
+		if !hasValue && !isBoolFlag(f) {
+			n++
+		}
+	}
+	n = min(n, len(args))
+
+	if err := fs.Parse(args[:n]); err != nil {
+		return nil, err
+	}
+	return args[n:], nil
+}
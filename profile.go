@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// apiProfile represents the relevant fields of a user's profile from
+// /users/:id.
+type apiProfile struct {
+	LastActivityOn string `json:"last_activity_on"` // YYYY-MM-DD; only shown to administrators
+	LocalTime      string `json:"local_time"`       // e.g. "2:50 PM", in the user's timezone
+	PublicEmail    string `json:"public_email"`     // empty unless the user chose one
+
+	Identities []identity `json:"identities"` // only shown to administrators
+}
+
+// identity is an account of a user with an external identity provider,
+// such as LDAP or SAML, as GitLab links them.
+type identity struct {
+	Provider  string `json:"provider"` // e.g. "ldapmain" or "group_saml"
+	ExternUID string `json:"extern_uid"`
+}
+
+func (id identity) String() string {
+	return id.Provider + ":" + id.ExternUID
+}
+
+// addIdentities returns ids with those of more that aren't in it yet.
+func addIdentities(ids []identity, more ...identity) []identity {
+	for _, id := range more {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// withProfiles returns members with details from their profile looked up,
+// one request per member: their public email address, timezone, external
+// identities (for administrators) and, with activity, the day they were
+// last active on GitLab. Members without a user ID (from git log) are left
+// as is.
+//
+// The users API only tells administrators when someone was last active, so
+// for everyone else the date of their latest visible event is used, at the
+// cost of another request.
+func withProfiles(members []Member, activity bool) ([]Member, error) {
+	if !slices.ContainsFunc(members, func(m Member) bool { return m.ID != 0 }) {
+		return members, nil
+	}
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+
+	members = append([]Member(nil), members...)
+	err = batch(len(members), func(i int) error {
+		if members[i].ID == 0 {
+			return nil
+		}
+		path := "/users/" + strconv.Itoa(members[i].ID)
+
+		var profile apiProfile
+		if err := client.get(path, nil, &profile); err != nil {
+			return fmt.Errorf("looking up the profile of %s: %w", members[i].Username, err)
+		}
+		// Authors from git log keep their commit email if there's no
+		// public one
+		if profile.PublicEmail != "" {
+			members[i].Email = profile.PublicEmail
+		}
+		members[i].Identities = addIdentities(members[i].Identities, profile.Identities...)
+		members[i].Timezone = utcOffset(profile.LocalTime, time.Now())
+		if !activity || profile.LastActivityOn != "" {
+			members[i].LastActivity = profile.LastActivityOn
+			return nil
+		}
+
+		var events []struct {
+			CreatedAt time.Time `json:"created_at"`
+		}
+		if err := client.get(path+"/events", url.Values{"per_page": {"1"}}, &events); err != nil {
+			return fmt.Errorf("looking up the activity of %s: %w", members[i].Username, err)
+		}
+		if len(events) > 0 {
+			members[i].LastActivity = events[0].CreatedAt.Format(time.DateOnly)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// lookUpProfiles returns members with their profiles looked up (see
+// withProfiles), or as they are, with a warning, if that fails.
+func lookUpProfiles(members []Member, activity bool) []Member {
+	withProfile, err := withProfiles(members, activity)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not look up profiles: %v\n", err)
+		return members
+	}
+	return withProfile
+}
+
+// utcOffset returns the UTC offset of a user whose local time was
+// localTime (like "2:50 PM") at now, e.g. "UTC+02:00", or "" if it can't
+// tell. GitLab doesn't show others the timezone users set, only the time
+// it is for them.
+func utcOffset(localTime string, now time.Time) string {
+	local, err := time.Parse(time.Kitchen, localTime)
+	if err != nil {
+		return ""
+	}
+	now = now.UTC()
+	offset := time.Duration(local.Hour()-now.Hour())*time.Hour + time.Duration(local.Minute()-now.Minute())*time.Minute
+	// The local date may differ from the UTC one
+	switch {
+	case offset > 14*time.Hour:
+		offset -= 24 * time.Hour
+	case offset < -12*time.Hour:
+		offset += 24 * time.Hour
+	}
+	// Offsets are whole quarters of an hour; round off the minute that may
+	// have passed since GitLab rendered the time
+	offset = offset.Round(15 * time.Minute)
+
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, int(offset.Hours()), int(offset.Minutes())%60)
+}
+
+// inactiveFor reports whether m was last active more than d ago. Members
+// whose activity is unknown are not considered inactive.
+func (m Member) inactiveFor(d time.Duration) bool {
+	last, err := time.Parse(time.DateOnly, m.LastActivity)
+	if err != nil {
+		return false
+	}
+	// A day's activity counts until the end of that day
+	return time.Since(last.Add(24*time.Hour)) > d
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// stdin is shared by all prompts so that buffered input is not lost between
+// them when it is piped in.
+var stdin = bufio.NewReader(os.Stdin)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptLine prints prompt to stderr and reads a line from stdin. An empty
+// answer returns def.
+func promptLine(prompt, def string) (string, error) {
+	if def != "" {
+		prompt = fmt.Sprintf("%s [%s]", prompt, def)
+	}
+	fmt.Fprintf(stderr, "%s: ", prompt)
+
+	waitingForInput.Store(true)
+	defer waitingForInput.Store(false)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading input: %w", err)
+	}
+
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// confirm asks a yes/no question, returning def on an empty answer.
+func confirm(prompt string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+
+	answer, err := promptLine(fmt.Sprintf("%s [%s]", prompt, hint), "")
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// promptSecret prints prompt to stderr and reads a line from stdin. When
+// stdin is a terminal, echo is turned off while typing.
+func promptSecret(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(stderr, prompt)
+		if err := setEcho(false); err == nil {
+			defer func() {
+				setEcho(true)
+				fmt.Fprintln(stderr)
+			}()
+		}
+	}
+
+	waitingForInput.Store(true)
+	defer waitingForInput.Store(false)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading token: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// openBrowser opens url in the default browser without waiting for it.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
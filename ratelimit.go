@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitReserve is the share of the rate limit budget below which we
+// start spacing out requests, so that batch scripts don't run the token
+// into the limit and get it blocked for a while.
+const rateLimitReserve = 0.1
+
+// rateLimit tracks the budget GitLab reports in the RateLimit-* headers.
+type rateLimit struct {
+	mu        sync.Mutex
+	known     bool
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// update records the budget from the headers of a response.
+func (r *rateLimit) update(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(header.Get("RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+
+	r.mu.Lock()
+	r.known, r.limit, r.remaining = true, limit, remaining
+	if reset > 0 {
+		r.reset = time.Unix(reset, 0)
+	}
+	r.mu.Unlock()
+
+	if reset > 0 {
+		verbosef("rate limit: %d of %d requests left, resets in %s", remaining, limit, time.Until(time.Unix(reset, 0)).Round(time.Second))
+	} else {
+		verbosef("rate limit: %d of %d requests left", remaining, limit)
+	}
+}
+
+// delay returns how long to wait before the next request. Once the budget
+// is nearly used up, the remaining requests are spread evenly over the time
+// until it resets.
+func (r *rateLimit) delay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.known || r.limit <= 0 || float64(r.remaining) > rateLimitReserve*float64(r.limit) {
+		return 0
+	}
+	window := time.Until(r.reset)
+	if window <= 0 {
+		return 0
+	}
+	return window / time.Duration(r.remaining+1)
+}
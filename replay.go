@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// exchange is an API request and its response, as kept by --record and
+// served by --replay. Request headers are left out, so the token never ends
+// up in a recording, and other secrets are masked (see redact).
+type exchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        string      `json:"body"`
+}
+
+// key identifies the requests an exchange is a response to.
+func (e *exchange) key() string {
+	return e.Method + " " + e.URL + "\n" + e.RequestBody
+}
+
+// recordingTransport writes every exchange to a file, which --replay can
+// serve again later.
+type recordingTransport struct {
+	next http.RoundTripper
+	path string
+
+	mu        sync.Mutex
+	exchanges []exchange
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := exchange{Method: req.Method, URL: redact(req.URL.String())}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		e.RequestBody = redact(string(data))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	e.Status = resp.StatusCode
+	e.Header = resp.Header.Clone()
+	// The body is stored decompressed and possibly masked
+	e.Header.Del("Content-Length")
+	e.Header.Del("Set-Cookie")
+	e.Body = redact(string(data))
+
+	// The file is rewritten after every exchange, so that it is complete
+	// however the run ends
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exchanges = append(t.exchanges, e)
+	if err := writeExchanges(t.path, t.exchanges); err != nil {
+		return nil, fmt.Errorf("%w: --record: %w", errHTTPSettings, err)
+	}
+	return resp, nil
+}
+
+func writeExchanges(path string, exchanges []exchange) error {
+	data, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// replayTransport answers requests from a file written by --record,
+// without any network access. Requests that were made more than once are
+// answered in the order they were recorded, after which the last answer is
+// repeated.
+type replayTransport struct {
+	mu        sync.Mutex
+	exchanges map[string][]exchange
+}
+
+func newReplayTransport(path string) (*replayTransport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("--replay: %w", err)
+	}
+	var exchanges []exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("--replay: parsing %s: %w", path, err)
+	}
+
+	t := &replayTransport{exchanges: make(map[string][]exchange)}
+	for _, e := range exchanges {
+		t.exchanges[e.key()] = append(t.exchanges[e.key()], e)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := exchange{Method: req.Method, URL: redact(req.URL.String())}
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		e.RequestBody = redact(string(data))
+	}
+
+	t.mu.Lock()
+	recorded := t.exchanges[e.key()]
+	if len(recorded) > 1 {
+		t.exchanges[e.key()] = recorded[1:]
+	}
+	t.mu.Unlock()
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("%w: --replay: no response recorded for %s %s", errHTTPSettings, e.Method, e.URL)
+	}
+
+	found := recorded[0]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", found.Status, http.StatusText(found.Status)),
+		StatusCode:    found.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        found.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader([]byte(found.Body))),
+		ContentLength: int64(len(found.Body)),
+		Request:       req,
+	}, nil
+}
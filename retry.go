@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxAttempts caps how often a single request is tried.
+	maxAttempts = 5
+
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// retryBudget caps the time spent on a request including its retries, so
+// that a struggling server makes us fall back to the cache soon: three
+// times the timeout, which is 30s by default.
+func retryBudget() time.Duration {
+	return 3 * conf.timeout()
+}
+
+// retryDelay reports whether a request that got resp (or failed with err)
+// on its attempt-th try should be retried, and after how long. Rate limited
+// requests (429) are always retried; server errors and network failures
+// only for methods that are safe to repeat. The server's Retry-After wins
+// over our own backoff.
+func retryDelay(method string, resp *http.Response, err error, attempt int, elapsed time.Duration) (time.Duration, bool) {
+	if attempt >= maxAttempts {
+		return 0, false
+	}
+
+	idempotent := method != http.MethodPost && method != http.MethodPatch
+	switch {
+	case err != nil:
+		// An unknown host or untrusted certificate won't go away in a second
+		var dnsErr *net.DNSError
+		var certErr *tls.CertificateVerificationError
+		if !idempotent || ctx.Err() != nil || errors.Is(err, errHTTPSettings) ||
+			(errors.As(err, &dnsErr) && dnsErr.IsNotFound) || errors.As(err, &certErr) {
+			return 0, false
+		}
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+		if !idempotent {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	delay := backoff(attempt)
+	if resp != nil {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			delay = after
+		}
+	}
+	if elapsed+delay > retryBudget() {
+		return 0, false
+	}
+	return delay, true
+}
+
+// sleep waits for d, or until ctx is cancelled.
+func sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the jittered exponential delay before retry attempt+1:
+// a random duration between half and all of 0.5s, 1s, 2s, ... capped at
+// retryMaxDelay. The jitter keeps concurrent requests from retrying in
+// lockstep.
+func backoff(attempt int) time.Duration {
+	d := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return d/2 + rand.N(d/2)
+}
+
+// parseRetryAfter parses a Retry-After header, which holds either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
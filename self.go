@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// selfFile is the file in the cache directory that maps tokens, by host and
+// a hash of the token, to the username they belong to.
+const selfFile = "self.json"
+
+// selfUsername returns the username of the user the token belongs to. It is
+// looked up once per token and cached, since a token can't change hands.
+// CI job tokens can't read their user, but GitLab sets $GITLAB_USER_LOGIN
+// to the user who started the job.
+func selfUsername() (string, error) {
+	client, err := newGitLabClient()
+	if err != nil {
+		return "", err
+	}
+	if client.cred.job {
+		if login := os.Getenv("GITLAB_USER_LOGIN"); login != "" {
+			return login, nil
+		}
+		return "", fmt.Errorf("CI job tokens can't look up their user, and $GITLAB_USER_LOGIN is not set")
+	}
+
+	sum := sha256.Sum256([]byte(client.cred.token))
+	key := client.host + " " + hex.EncodeToString(sum[:8])
+	if users, err := readCacheMap[string](selfFile); err == nil && users[key] != "" {
+		return users[key], nil
+	}
+
+	if err := checkBreaker(client.project.Host); err != nil {
+		return "", err
+	}
+	user, err := currentUser(client)
+	if err != nil {
+		return "", err
+	}
+
+	err = updateCacheMap(selfFile, func(users map[string]string) {
+		users[key] = user.Username
+	})
+	if err != nil {
+		verbosef("could not cache the current user: %v", err)
+	}
+	return user.Username, nil
+}
+
+// lookUpSelf returns selfUsername, or "" with a warning if it can't be
+// determined. Without a token there is nothing to warn about: we can't be
+// a member then, as far as the member list knows.
+func lookUpSelf() string {
+	username, err := selfUsername()
+	if err != nil {
+		if !errors.Is(err, errNoToken) {
+			fmt.Fprintf(stderr, "warning: could not look up your own username: %v\n", err)
+		}
+		return ""
+	}
+	return username
+}
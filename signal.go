@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// ctx is cancelled when we are interrupted with Ctrl-C or SIGTERM, so that
+// in-flight API requests and git commands are aborted right away instead of
+// running into their timeout.
+var ctx = context.Background()
+
+// waitingForInput is set while a prompt waits for the user, since reading
+// from the terminal can't be cancelled.
+var waitingForInput atomic.Bool
+
+// exitInterrupted is the exit status after an interrupt, as with shells.
+const exitInterrupted = 130
+
+// cancelOnSignal sets up ctx to be cancelled on SIGINT and SIGTERM. At a
+// prompt, or on a second signal, we exit right away.
+func cancelOnSignal() {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		if waitingForInput.Load() {
+			setEcho(true)
+			fmt.Fprintln(stderr)
+			os.Exit(exitInterrupted)
+		}
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// userStatus is the status a GitLab user set on their profile.
+type userStatus struct {
+	Emoji   string `json:"emoji,omitempty"`
+	Message string `json:"message,omitempty"`
+	Busy    bool   `json:"busy,omitempty"` // availability set to "busy"
+}
+
+// apiStatus represents the relevant fields of a user status.
+type apiStatus struct {
+	Emoji        string `json:"emoji"`
+	Message      string `json:"message"`
+	Availability string `json:"availability"` // "not_set" or "busy"
+}
+
+// awayRe matches status messages of people who are out of office.
+var awayRe = regexp.MustCompile(`(?i)\b(ooo|out of (the )?office|vacation|holidays?|on leave|parental leave|sick|pto)\b`)
+
+// awayEmoji are status emoji that mean someone is out of office.
+var awayEmoji = map[string]bool{
+	"airplane":              true,
+	"beach_umbrella":        true,
+	"desert_island":         true,
+	"face_with_thermometer": true,
+	"palm_tree":             true,
+	"thermometer_face":      true,
+}
+
+// away reports whether the status says the user can't review right now:
+// they are marked busy, or the status looks like an out of office one.
+func (s *userStatus) away() bool {
+	return s != nil && (s.Busy || awayEmoji[s.Emoji] || awayRe.MatchString(s.Message))
+}
+
+// String formats the status like ":palm_tree: On vacation".
+func (s *userStatus) String() string {
+	if s == nil {
+		return ""
+	}
+	if s.Emoji == "" {
+		return s.Message
+	}
+	if s.Message == "" {
+		return ":" + s.Emoji + ":"
+	}
+	return ":" + s.Emoji + ": " + s.Message
+}
+
+// withStatuses returns members with their statuses looked up, one request
+// per member. Members without a user ID (from git log) are left as is.
+func withStatuses(members []Member) ([]Member, error) {
+	client, err := newGitLabClient()
+	if err != nil {
+		return nil, err
+	}
+
+	members = append([]Member(nil), members...)
+	err = batch(len(members), func(i int) error {
+		if members[i].ID == 0 {
+			return nil
+		}
+		var status apiStatus
+		if err := client.get("/users/"+strconv.Itoa(members[i].ID)+"/status", nil, &status); err != nil {
+			return fmt.Errorf("looking up the status of %s: %w", members[i].Username, err)
+		}
+		members[i].Status = &userStatus{
+			Emoji:   status.Emoji,
+			Message: status.Message,
+			Busy:    status.Availability == "busy",
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// lookUpStatuses returns members with their statuses looked up, or as they
+// are, with a warning, if that fails.
+func lookUpStatuses(members []Member) []Member {
+	withStatus, err := withStatuses(members)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not look up statuses: %v\n", err)
+		return members
+	}
+	return withStatus
+}
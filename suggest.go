@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyDepth caps how many commits are scanned for contribution history.
+const historyDepth = 2000
+
+// suggestion is a member proposed as reviewer, with the number of changed
+// files they previously touched (summed over commits) as score.
+type suggestion struct {
+	Member
+	Score int `json:"score"`
+}
+
+func newSuggestCommand() *command {
+	fs := newFlagSet("suggest")
+	count := fs.Int("n", 3, "Number of reviewers to suggest")
+	fs.IntVar(count, "limit", 3, "Same as -n")
+	base := fs.String("base", "", "Base `ref` the current branch is compared against (default: HEAD of the git remote)")
+	refresh := fs.Bool("refresh", false, "Force refresh the member cache from GitLab API")
+	jsonOut := fs.Bool("json", conf.jsonOutput(), "Output as JSON instead of TSV")
+	minAccess := fs.String("min-access", conf.MinAccess, "Only suggest members with at least this access `level` (e.g. developer)")
+	fields := fs.String("fields", strings.Join(conf.fields(), ","), "Comma-separated TSV `columns`: "+strings.Join(fieldNames(), ", "))
+	skipBusy := fs.Bool("skip-busy", false, "Don't suggest members whose status says they are busy or out of office")
+	var skipInactive duration
+	fs.TextVar(&skipInactive, "skip-inactive", duration(0), "Don't suggest members who have not been active on GitLab for this `long` (e.g. 90d)")
+	includeBots := fs.Bool("include-bots", false, "Also suggest bot users, such as those of access tokens")
+	excludeSelf := fs.Bool("exclude-self", true, "Don't suggest the user the token belongs to")
+	direct := fs.Bool("direct", false, "Only suggest direct members of the project, not those inherited from its groups or shared groups")
+	var skipExpiring duration
+	fs.TextVar(&skipExpiring, "skip-expiring", duration(0), "Don't suggest members whose membership expires within this `long` (e.g. 14d)")
+	canMerge := fs.Bool("can-merge", false, "Only suggest members allowed to merge into -base, or else the target branch of the current branch's merge request, or the default branch")
+
+	return &command{
+		name:    "suggest",
+		summary: "Suggest reviewers for the current change",
+		help: `Suggest reviewers for the changes on the current branch, including
+uncommitted ones. Files changed since the merge base with the base ref are
+looked up in the history before that point, and project members are ranked
+by how often they touched those files. Your own commits are ignored, and
+you aren't suggested yourself unless -exclude-self=false is given.
+
+Output uses the same name<TAB>username format as "members".`,
+		flags:      fs,
+		flagValues: map[string]func() []string{"fields": fieldNames, "min-access": completeAccessLevels},
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+			if *count < 1 {
+				// -limit sets the same count; name the flag that was given
+				name := "-n"
+				fs.Visit(func(f *flag.Flag) {
+					if f.Name == "limit" {
+						name = "-limit"
+					}
+				})
+				return fmt.Errorf("%s must be at least 1", name)
+			}
+
+			exclude, err := excludePatterns()
+			if err != nil {
+				return err
+			}
+			filter := memberFilter{
+				State:          "active",
+				Exclude:        exclude,
+				IncludeBots:    *includeBots,
+				Direct:         *direct,
+				ExpiringWithin: time.Duration(skipExpiring),
+			}
+			if *excludeSelf {
+				filter.Self = lookUpSelf()
+			}
+			if *canMerge {
+				if filter.Merge, err = lookUpMergeAccess(*base); err != nil {
+					return err
+				}
+			}
+			if *minAccess != "" {
+				level, err := parseAccessLevel(*minAccess)
+				if err != nil {
+					return err
+				}
+				filter.MinAccess = level
+			}
+			columns, err := parseFields(*fields)
+			if err != nil {
+				return err
+			}
+
+			suggestions, err := suggestReviewers(*base, *refresh, filter)
+			if err != nil {
+				return err
+			}
+			suggestions = applyLookups(suggestions, newMemberLookups(columns, *skipBusy, time.Duration(skipInactive)))
+			if len(suggestions) > *count {
+				suggestions = suggestions[:*count]
+			}
+
+			return printSuggestions(os.Stdout, suggestions, *jsonOut, columns)
+		},
+	}
+}
+
+func printSuggestions(w io.Writer, suggestions []suggestion, jsonOut bool, fields []string) error {
+	if jsonOut {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(suggestions); err != nil {
+			return fmt.Errorf("encoding json: %w", err)
+		}
+		return nil
+	}
+
+	for _, s := range suggestions {
+		fmt.Fprintln(w, memberRow(s.Member, fields))
+	}
+	return nil
+}
+
+// applyLookups looks up the details of the suggested members and leaves
+// out those that don't pass the filters of lookups.
+func applyLookups(suggestions []suggestion, lookups memberLookups) []suggestion {
+	members := make([]Member, len(suggestions))
+	for i, s := range suggestions {
+		members[i] = s.Member
+	}
+
+	kept := []suggestion{}
+	for i, m := range lookups.apply(members) {
+		if lookups.keep(m) {
+			kept = append(kept, suggestion{Member: m, Score: suggestions[i].Score})
+		}
+	}
+	return kept
+}
+
+// suggestReviewers ranks the project members matching filter by their
+// contributions to the files changed relative to base.
+func suggestReviewers(base string, forceRefresh bool, filter memberFilter) ([]suggestion, error) {
+	if base == "" {
+		var err error
+		if base, err = defaultBaseRef(); err != nil {
+			return nil, err
+		}
+	}
+
+	mergeBase, err := gitOutput("merge-base", "HEAD", base)
+	if err != nil {
+		return nil, fmt.Errorf("finding merge base with %s: %w", base, err)
+	}
+
+	files, err := changedFiles(mergeBase)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no changes found relative to %s", base)
+	}
+
+	authors, err := contributionCounts(mergeBase, files)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := getMembers(forceRefresh, "", "")
+	if err != nil {
+		return nil, err
+	}
+	members = filterMembers(members, filter)
+
+	scores := make(map[int]int) // index into members -> score
+	for _, a := range authors {
+		if i := matchAuthor(members, a.name, a.email); i >= 0 {
+			scores[i] += a.count
+		}
+	}
+
+	suggestions := []suggestion{}
+	for i, score := range scores {
+		suggestions = append(suggestions, suggestion{Member: members[i], Score: score})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Name < suggestions[j].Name
+	})
+
+	return suggestions, nil
+}
+
+// defaultBaseRef returns the branch <remote>/HEAD points to, falling back
+// to common default branch names.
+func defaultBaseRef() (string, error) {
+	remote := remoteName()
+	if ref, err := gitOutput("symbolic-ref", "--quiet", "--short", "refs/remotes/"+remote+"/HEAD"); err == nil {
+		return ref, nil
+	}
+
+	for _, ref := range []string{remote + "/main", remote + "/master", "main", "master"} {
+		if _, err := gitOutput("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+			return ref, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not determine base branch, pass one with -base")
+}
+
+// changedFiles lists files that differ between rev and the working tree,
+// which covers both commits on the current branch and uncommitted changes.
+// A bare repository has no working tree, so HEAD is compared instead.
+func changedFiles(rev string) ([]string, error) {
+	args := []string{"diff", "--name-only", rev}
+	if bare, _ := gitOutput("rev-parse", "--is-bare-repository"); bare == "true" {
+		args = append(args, "HEAD")
+	}
+	out, err := gitOutput(args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing changed files: %w", err)
+	}
+	return splitLines(out), nil
+}
+
+// authorContribution counts how many changed files an author touched,
+// summed over all commits.
+type authorContribution struct {
+	name  string
+	email string
+	count int
+}
+
+// contributionCounts scans the history up to rev for commits touching
+// files. Commits by the current git user are skipped.
+func contributionCounts(rev string, files []string) ([]authorContribution, error) {
+	selfName, _ := gitOutput("config", "user.name")
+	selfEmail, _ := gitOutput("config", "user.email")
+
+	args := []string{"log", "--no-merges", fmt.Sprintf("--max-count=%d", historyDepth),
+		"--format=%x1e%aN%x1f%aE", "--name-only", rev, "--"}
+	out, err := gitOutput(append(args, files...)...)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	index := make(map[string]int)
+	var authors []authorContribution
+
+	for _, record := range strings.Split(out, "\x1e") {
+		lines := splitLines(record)
+		if len(lines) == 0 {
+			continue
+		}
+
+		name, email, _ := strings.Cut(lines[0], "\x1f")
+		if (selfEmail != "" && strings.EqualFold(email, selfEmail)) || (selfName != "" && name == selfName) {
+			continue
+		}
+
+		key := strings.ToLower(email)
+		i, ok := index[key]
+		if !ok {
+			i = len(authors)
+			index[key] = i
+			authors = append(authors, authorContribution{name: name, email: email})
+		}
+		authors[i].count += len(lines) - 1
+	}
+
+	return authors, nil
+}
+
+// matchAuthor returns the index of the member corresponding to a git
+// author, matching on display name or on the local part of the email
+// address against the username. It returns -1 if there is no match.
+func matchAuthor(members []Member, name, email string) int {
+	local, _, _ := strings.Cut(email, "@")
+	for i, m := range members {
+		if strings.EqualFold(m.Name, name) {
+			return i
+		}
+		if m.Username != "" && (strings.EqualFold(m.Username, local) || strings.EqualFold(m.Username, name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// gitCommand returns a git command for args, run in the directory given
+// with -C, or in the superproject with --superproject. GIT_DIR and
+// GIT_WORK_TREE are passed on through the environment.
+func gitCommand(args ...string) *exec.Cmd {
+	dir := options.dir
+	if options.superproject {
+		if super := superproject(); super != "" {
+			dir = super
+		}
+	}
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	return exec.CommandContext(ctx, "git", args...)
+}
+
+// superprojectDir caches the result of superproject.
+var superprojectDir *string
+
+// superproject returns the working tree of the repository the current one
+// is a submodule of, or "" if it isn't one.
+func superproject() string {
+	if superprojectDir == nil {
+		args := []string{"rev-parse", "--show-superproject-working-tree"}
+		if options.dir != "" {
+			args = append([]string{"-C", options.dir}, args...)
+		}
+		out, _ := exec.CommandContext(ctx, "git", args...).Output()
+		dir := strings.TrimSpace(string(out))
+		superprojectDir = &dir
+	}
+	return *superprojectDir
+}
+
+// gitOutput runs git with args and returns its trimmed stdout.
+func gitOutput(args ...string) (string, error) {
+	out, err := gitCommand(args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// tokenCommand returns the configured token command for host; a per-host
+// setting wins over the global one.
+func (c *Config) tokenCommand(host string) string {
+	if h, ok := c.Hosts[host]; ok && h.TokenCommand != "" {
+		return h.TokenCommand
+	}
+	return c.TokenCommand
+}
+
+// runTokenCommand runs command through the shell and returns its trimmed
+// stdout as the token. GITLAB_HOST is set to host so that a single command
+// can serve several hosts.
+func runTokenCommand(command, host string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "GITLAB_HOST="+host)
+	// Let password managers prompt for a passphrase on the terminal, but
+	// don't hand them piped input meant for us
+	if isTerminal(os.Stdin) {
+		cmd.Stdin = os.Stdin
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("token_command failed: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("token_command failed: %w", err)
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token_command printed no token")
+	}
+	return token, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHTTPSettings marks requests that failed because the proxy, TLS or
+// record/replay settings can't be used, which no retry will fix.
+var errHTTPSettings = errors.New("invalid HTTP settings")
+
+// failingTransport fails every request with err.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%w: %w", errHTTPSettings, t.err)
+}
+
+// httpClients are the clients made by httpClient, by host.
+var (
+	httpClientsMu sync.Mutex
+	httpClients   = make(map[string]*http.Client)
+)
+
+// httpClient returns the client for requests to GitLab on host (the
+// remote's host, which the hosts settings are keyed by). There is one
+// client per host for the whole run, so that every request to it can reuse
+// the connections, and TLS sessions, of earlier ones. With --debug-http,
+// every request is traced on stderr. With --record, every exchange is
+// written to a file; with --replay, they are answered from one instead.
+func httpClient(host string) *http.Client {
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := httpClients[host]; ok {
+		return client
+	}
+
+	transport := newTransport(host)
+	switch {
+	case options.record != "" && options.replay != "":
+		transport = failingTransport{fmt.Errorf("--record and --replay can't be combined")}
+	case options.record != "":
+		transport = &recordingTransport{next: transport, path: options.record}
+	case options.replay != "":
+		if replay, err := newReplayTransport(options.replay); err != nil {
+			transport = failingTransport{err}
+		} else {
+			transport = replay
+		}
+	}
+	if options.debugHTTP {
+		transport = tracingTransport{next: transport}
+	}
+	client := &http.Client{Timeout: conf.timeout(), Transport: transport}
+	httpClients[host] = client
+	return client
+}
+
+// newTransport returns the transport for requests to GitLab on host.
+// Proxies are taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY unless one is
+// set with --proxy or the proxy setting. If the settings are invalid, every
+// request fails.
+func newTransport(host string) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Keep a connection for each worker of a batch (the default is 2)
+	transport.MaxIdleConnsPerHost = batchWorkers
+
+	if proxy := proxySetting(); proxy != "" {
+		proxyURL, err := parseProxyURL(proxy)
+		if err != nil {
+			return failingTransport{err}
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := hostTLSConfig(host)
+	if err != nil {
+		return failingTransport{err}
+	}
+	if options.insecure {
+		warnInsecure()
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport
+}
+
+// tracedHeaders are the response headers --debug-http shows: the rate
+// limit, and the request ID GitLab administrators can look up in their logs.
+var tracedHeaders = []string{
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+	"X-Request-Id",
+}
+
+// tracingTransport prints every request and its response to stderr, which
+// masks the token.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	// Written in one go, as pages are fetched concurrently
+	var b strings.Builder
+	fmt.Fprintf(&b, "> %s %s\n", req.Method, req.URL)
+	for _, name := range slices.Sorted(maps.Keys(req.Header)) {
+		fmt.Fprintf(&b, "> %s: %s\n", name, strings.Join(req.Header[name], ", "))
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "< error after %s: %v\n", elapsed, err)
+	} else {
+		fmt.Fprintf(&b, "< %s in %s\n", resp.Status, elapsed)
+		for _, name := range tracedHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				fmt.Fprintf(&b, "< %s: %s\n", name, value)
+			}
+		}
+	}
+	io.WriteString(stderr, b.String())
+
+	return resp, err
+}
+
+var insecureWarned sync.Once
+
+// warnInsecure warns, once per run, that --insecure is in effect.
+func warnInsecure() {
+	insecureWarned.Do(func() {
+		fmt.Fprintf(stderr, "warning: --insecure: TLS certificates are NOT verified, anyone on the network path can read your token\n")
+	})
+}
+
+// proxySetting returns the proxy given with --proxy or in the config.
+func proxySetting() string {
+	if options.proxy != "" {
+		return options.proxy
+	}
+	return conf.Proxy
+}
+
+// parseProxyURL parses an http, https or socks5 proxy URL. Without a
+// scheme, an HTTP proxy is assumed.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", stripUserinfo(proxy))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %s (want http, https or socks5)", stripUserinfo(proxy), u.Scheme)
+}
+
+// hostTLSConfig returns the TLS settings for host: a CA bundle to trust in
+// addition to the system's, for instances with a private CA, and a client
+// certificate for reverse proxies that require one. It returns nil if host
+// has neither.
+func hostTLSConfig(host string) (*tls.Config, error) {
+	h := conf.Hosts[host]
+	if h.CAFile == "" && h.ClientCert == "" && h.ClientKey == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if h.CAFile != "" {
+		path, err := expandHome(h.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading hosts.%s.ca_file: %w", host, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("hosts.%s.ca_file: no PEM certificates in %s", host, path)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if h.ClientCert != "" || h.ClientKey != "" {
+		if h.ClientCert == "" || h.ClientKey == "" {
+			return nil, fmt.Errorf("hosts.%s: client_cert and client_key must be set together", host)
+		}
+		certPath, err := expandHome(h.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		keyPath, err := expandHome(h.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading the client certificate for %s: %w", host, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.date=...". Unset values are filled in from the Go build info.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+func newVersionCommand() *command {
+	return &command{
+		name:    "version",
+		summary: "Print version and build information",
+		run: func(args []string) error {
+			if err := noArgs(args); err != nil {
+				return err
+			}
+			fmt.Println(versionString())
+			return nil
+		},
+	}
+}
+
+// versionString describes the binary, e.g.
+// "gitlab-reviewer 0.1.0 (commit 1a2b3c4, built 2026-01-02T03:04:05Z, go1.25.0)".
+func versionString() string {
+	v, c, d := version, commit, date
+	dirty := false
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			case s.Key == "vcs.modified" && commit == "":
+				dirty = s.Value == "true"
+			}
+		}
+	}
+
+	if v == "" {
+		v = "dev"
+	}
+	if len(c) > 12 {
+		c = c[:12]
+	}
+	if c == "" {
+		c = "unknown"
+	} else if dirty {
+		c += "-dirty"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+
+	return fmt.Sprintf("gitlab-reviewer %s (commit %s, built %s, %s)", v, c, d, runtime.Version())
+}